@@ -115,7 +115,7 @@ func main() {
 		aTrans, bTrans int
 		desc           string
 	}{
-		{shmeh.E(weights.U("j").D("k").D("i")),
+		{shmeh.E(weights.U("j").D("k").D("i")).Permissive(),
 			"dud",
 			0, 0,
 			"\nLet's examine the initial weights for our three layer system." +
@@ -212,10 +212,9 @@ func main() {
 	backProp1 := shmeh.E(
 		pad.D("a").U("z"),
 		tWeights.D("z").U("b").D("c"),
-		errorInOutput.U("c").D("d"),
-		dirac3(4, 4, 4).U("d").D("e").U("a"), // Used to tie the indices of the weights and activations.
-		leftShift.U("e").D("f"),              // Left-shift.
-	)
+		errorInOutput.U("c").D("a"), // Sharing a ties the indices of the weights and activations.
+		leftShift.U("a").D("f"),     // Left-shift.
+	).Generalized()
 	fmt.Printf("\nError %v\n", errorInOutput)
 	for i := 0; i < 3; i++ {
 		fmt.Printf("%v application", i)
@@ -238,11 +237,12 @@ func main() {
 	activations = activations
 	errors = errors
 
+	// The Dirac delta is "udu" but used as "udd".
 	delErrorWrtWeights := shmeh.E(
 		dirac3(3, 4, 4).U("x").D("b").D("c"),
 		activations.U("a").D("b"),
 		errors.U("d").D("c"),
-	)
+	).Permissive()
 	fmt.Printf("Current Weights\n")
 	VisualizePolynomial(weights, nil, nil)
 	fmt.Printf("Activations %v", activations)
@@ -286,28 +286,12 @@ func identity(i ...int) int {
 	return 1
 }
 
-func identityFloat(i ...int) float64 {
-	val := i[0]
-	for _, elt := range i {
-		if elt != val {
-			return 0
-		}
-	}
-	return 1
-}
-
-var dirac_delta4 = shmeh.NewRealTensor(
-	identityFloat,
-	"udu",
-	[]int{4, 4, 3},
-)
+var dirac_delta4 = shmeh.Identity(shmeh.RealType, "udu", []int{4, 4, 3})
 
+// dirac3 ties three indices together even when their dimensions
+// differ, which sharing one index in a Generalized term can't.
 func dirac3(x, y, z int) *shmeh.Tensor {
-	t := shmeh.NewRealTensor(
-		identityFloat,
-		"udu",
-		[]int{x, y, z},
-	)
+	t := shmeh.Identity(shmeh.RealType, "udu", []int{x, y, z})
 	return &t
 }
 
@@ -315,11 +299,7 @@ func dirac3(x, y, z int) *shmeh.Tensor {
 // embed an input a vector in a different vector space.
 // When larger, it zero-pads all new dimensions.
 func Embed(inputDim, outputDim int) *shmeh.Tensor {
-	t := shmeh.NewRealTensor(
-		identityFloat,
-		"ud",
-		[]int{outputDim, inputDim},
-	)
+	t := shmeh.Identity(shmeh.RealType, "ud", []int{outputDim, inputDim})
 	return &t
 }
 
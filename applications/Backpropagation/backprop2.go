@@ -21,7 +21,7 @@ import (
 	"math"
 )
 
-func weightedInput(weights, activation, biases shmeh.Tensor) shmeh.Tensor {
+func weightedInput(weights, activation, biases shmeh.Tensor) shmeh.Evaluator {
 	rightShift := newMatrix(
 		[]float64{0, 1, 0},
 		[]float64{0, 0, 1},
@@ -35,12 +35,7 @@ func weightedInput(weights, activation, biases shmeh.Tensor) shmeh.Tensor {
 			dirac3(3, 3, 2).U("d").D("e").U("a"), // Multiply weight matrix i by activation column i
 			rightShift.U("e").D("f"),             // Move signal forward one column.
 		)}
-
-	s, err, _ := expression.Eval()
-	if err != nil {
-		panic(err)
-	}
-	return s
+	return expression
 }
 
 func forwardPass(weights, activation, biases shmeh.Tensor) shmeh.Tensor {
@@ -74,14 +69,11 @@ func backwardPass(weights, errors, delSigmaZ shmeh.Tensor) shmeh.Tensor {
 
 	expression := shmeh.E(
 		tWeights.D("a").U("b").D("c"),
-		errors.U("c").D("d"),
-		dirac3(2, 2, 2).U("d").D("e").U("a"), // Multiply weight matrix i by activation column i
-		leftShift.U("e").D("f"),              // Move signal backward one column.
+		errors.U("c").D("a"),    // Multiply weight matrix i by activation column i
+		leftShift.U("a").D("f"), // Move signal backward one column.
 		// Finish with a Hadamard product of this U(b).D(f) with delSigma
-		delSigmaZ.U("w").D("x"),
-		dirac3(2, 2, 2).U("w").D("y").U("b"),
-		dirac3(2, 2, 2).U("x").D("z").U("f"),
-	)
+		delSigmaZ.U("b").D("f"),
+	).Generalized().To("bf")
 
 	s, err, _ := expression.Eval()
 	if err != nil {
@@ -96,17 +88,6 @@ func delSigmaZ(activation, biases shmeh.Tensor) shmeh.Tensor {
 		sigmoid := math.Exp(r) / (1. + math.Exp(r))
 		return sigmoid * (1 - sigmoid)
 	})
-	expression :=
-		shmeh.Apply{
-			delSigmoid, // DelSigmoid function.
-			weightedInput(weights, activation, biases),
-		}
-
-	s, err, _ := expression.Eval()
-	if err != nil {
-		panic(err)
-	}
-
 	// Finish by cutting off the input column.
 	// No weighted activation there.
 	cutLeftColumn := newMatrix( //mix of cut and shift-left.
@@ -115,11 +96,14 @@ func delSigmaZ(activation, biases shmeh.Tensor) shmeh.Tensor {
 		[]float64{0, 1},
 	)
 
-	cut := shmeh.E(
-		s.U("a").D("b"),
+	expression := shmeh.E(
+		shmeh.Apply{
+			Func: delSigmoid, // DelSigmoid function.
+			E:    weightedInput(weights, activation, biases),
+		}.U("a").D("b"),
 		cutLeftColumn.U("b").D("c"),
 	)
-	s, err, _ = cut.Eval()
+	s, err, _ := expression.Eval()
 	if err != nil {
 		panic(err)
 	}
@@ -213,15 +197,11 @@ func getUpdatedWeights(errors, activations shmeh.Tensor) shmeh.Tensor {
 		activations.U("a").D("b"),
 		cutLeftColumn.U("b").D("c"),
 	)
-	activations, err, _ := cut.Eval()
-	if err != nil {
-		panic(err)
-	}
+	// Weights are indexed by layer, output neuron, then input neuron.
 	expression := shmeh.E(
-		dirac3(2, 2, 2).U("x").D("b").D("c"),
-		activations.U("a").D("b"),
-		errors.U("d").D("c"),
-	)
+		cut.U("a").D("x"),
+		errors.U("d").D("x"),
+	).Generalized().To("xda")
 
 	s, err, _ := expression.Eval()
 	if err != nil {
@@ -232,23 +212,7 @@ func getUpdatedWeights(errors, activations shmeh.Tensor) shmeh.Tensor {
 }
 
 func updateWeights(oldWeights, newWeights shmeh.Tensor, learningRate float64) shmeh.Tensor {
-	// transpose weights
-	// TODO(Xam: investigate this last transpose here.)
-	// Why was this necessary to get results to agree with mazur?
-	newWeights, err := shmeh.Transpose(newWeights, 1, 2)
-	if err != nil {
-		panic(err)
-	}
-	// Investigate further.
-
-	expression :=
-		shmeh.Plus{
-			oldWeights,
-			shmeh.Apply{
-				shmeh.NewRealScalar(-1. * learningRate),
-				newWeights,
-			},
-		}
+	expression := shmeh.Minus(oldWeights, shmeh.Scaled{C: learningRate, E: newWeights})
 	s, err, _ := expression.Eval()
 	if err != nil {
 		panic(err)
@@ -339,22 +303,10 @@ var weights = shmeh.NewRealTensor(
 	[]int{2, 2, 2},
 )
 
-func identityFloat(i ...int) float64 {
-	val := i[0]
-	for _, elt := range i {
-		if elt != val {
-			return 0
-		}
-	}
-	return 1
-}
-
+// dirac3 ties three indices together even when their dimensions
+// differ, which sharing one index in a Generalized term can't.
 func dirac3(x, y, z int) *shmeh.Tensor {
-	t := shmeh.NewRealTensor(
-		identityFloat,
-		"udu",
-		[]int{x, y, z},
-	)
+	t := shmeh.Identity(shmeh.RealType, "udu", []int{x, y, z})
 	return &t
 }
 
@@ -15,6 +15,7 @@ package main
 import (
 	"fmt"
 	shmeh "github.com/sillsm/shmensor/shmensor"
+	"math/big"
 	"reflect"
 )
 
@@ -30,16 +31,14 @@ func main() {
 		{E(bivec1.U("i").U("j")), "Bivector (2, 0.)"},
 		{E(s1.U(""), x1.U("i").D("j"), x2.U("k").D("l")),
 			"Evaluating a scalar times a tensor product of a row and column (2, 2)."},
-		// equals 36 until you divide by 6. To be supported later when extended to floats and bignum
-		{E(dirac_delta3.U("a").D("b").D("c"),
-			newVec(1, 2, 3, 4, 5).U("b"),
-			newVec(1, 2, 3, 4, 5).U("c")),
+		{E(newVec(1, 2, 3, 4, 5).U("a"),
+			newVec(1, 2, 3, 4, 5).U("a")).Generalized().To("a"),
 			"Hadamard Product (component wise multiplication) on <1,2,3,4,5>."},
-		//
+		// equals 36, 3! times the determinant. See the rational one below.
 		{E(eps.D("ijk"), eps.D("pqr"),
 			det1.U("p").D("i"),
 			det1.U("q").D("j"),
-			det1.U("r").D("k")),
+			det1.U("r").D("k")).Permissive(),
 			"Determinant of <1,2><3,4> in abstract index notation."},
 		//https://www.mathsisfun.com/algebra/vectors-cross-product.html
 		{E(eps.D("ijk"),
@@ -48,8 +47,8 @@ func main() {
 			"Cross product of <2,3,4> and <5,6,7> in abstract index notation."},
 		//https://www.wolframalpha.com/input/?i=%7B%7B2+%2B+6.5i,+1+-7.001i%7D,+%7B0,+3+%2B+i%7D%7D+*+%7B%7Bi%7D,%7B6%7D%7D
 		{E(complex1.U("i").D("j")), "Complex LHS"},
-		{E(complex2.D("j")), "Complex RHS"},
-		{E(complex1.U("i").D("j"), complex2.D("j")),
+		{E(complex2.U("j")), "Complex RHS"},
+		{E(complex1.U("i").D("j"), complex2.U("j")),
 			"Their product."},
 	}
 
@@ -61,16 +60,28 @@ func main() {
 		fmt.Printf("%v\n", elt.desc)
 		fmt.Printf("%v", tensor)
 	}
-}
 
-func identity(i ...int) int {
-	val := i[0]
-	for _, elt := range i {
-		if elt != val {
-			return 0
-		}
+	// With exact fractions the determinant can be divided by 3!.
+	rational := func(f func(i ...int) int, signature string, dim []int) *shmeh.Tensor {
+		t := shmeh.NewRationalTensor(func(i ...int) *big.Rat {
+			return big.NewRat(int64(f(i...)), 1)
+		}, signature, dim)
+		return &t
+	}
+	reps := rational(leviCivita, "ddd", []int{3, 3, 3})
+	rdet := rational(diagonal, "ud", []int{3, 3})
+	det, err, _ := shmeh.Scaled{
+		C: big.NewRat(1, 6),
+		E: E(reps.D("ijk"), reps.D("pqr"),
+			rdet.U("p").D("i"),
+			rdet.U("q").D("j"),
+			rdet.U("r").D("k")).Permissive(),
+	}.Eval()
+	if err != nil {
+		panic(err)
 	}
-	return 1
+	fmt.Printf("Determinant of <1,2,3> on the diagonal in exact fractions.\n")
+	fmt.Printf("%v", det)
 }
 
 // New vector helper function.
@@ -86,71 +97,43 @@ func newVec(i ...int) *shmeh.Tensor {
 
 // Levi-civita symbol on 3 letters.
 var eps = shmeh.NewIntTensor(
-	func(i ...int) int {
-		if reflect.DeepEqual(i, []int{0, 1, 2}) {
-			return 1
-		}
-		if reflect.DeepEqual(i, []int{1, 2, 0}) {
-			return 1
-		}
-		if reflect.DeepEqual(i, []int{2, 0, 1}) {
-			return 1
-		}
-		if reflect.DeepEqual(i, []int{2, 1, 0}) {
-			return -1
-		}
-		if reflect.DeepEqual(i, []int{1, 0, 2}) {
-			return -1
-		}
-		if reflect.DeepEqual(i, []int{0, 2, 1}) {
-			return -1
-		}
-		return 0
-	},
+	leviCivita,
 	"ddd",
 	[]int{3, 3, 3})
 
-var col1 = shmeh.NewIntTensor(
-	identity,
-	"u",
-	[]int{3},
-)
+func leviCivita(i ...int) int {
+	if reflect.DeepEqual(i, []int{0, 1, 2}) {
+		return 1
+	}
+	if reflect.DeepEqual(i, []int{1, 2, 0}) {
+		return 1
+	}
+	if reflect.DeepEqual(i, []int{2, 0, 1}) {
+		return 1
+	}
+	if reflect.DeepEqual(i, []int{2, 1, 0}) {
+		return -1
+	}
+	if reflect.DeepEqual(i, []int{1, 0, 2}) {
+		return -1
+	}
+	if reflect.DeepEqual(i, []int{0, 2, 1}) {
+		return -1
+	}
+	return 0
+}
 
-var row1 = shmeh.NewIntTensor(
-	identity,
-	"d",
-	[]int{3},
-)
+var col1 = shmeh.Identity(shmeh.IntType, "u", []int{3})
 
-var mat1 = shmeh.NewIntTensor(
-	identity,
-	"ud",
-	[]int{3, 3},
-)
+var row1 = shmeh.Identity(shmeh.IntType, "d", []int{3})
 
-var dirac_delta3 = shmeh.NewIntTensor(
-	identity,
-	"udd",
-	[]int{5, 5, 5},
-)
+var mat1 = shmeh.Identity(shmeh.IntType, "ud", []int{3, 3})
 
-var bivec1 = shmeh.NewIntTensor(
-	identity,
-	"uu",
-	[]int{3, 3},
-)
+var bivec1 = shmeh.Identity(shmeh.IntType, "uu", []int{3, 3})
 
-var bilinearform1 = shmeh.NewIntTensor(
-	identity,
-	"dd",
-	[]int{3, 3},
-)
+var bilinearform1 = shmeh.Identity(shmeh.IntType, "dd", []int{3, 3})
 
-var onetwo1 = shmeh.NewIntTensor(
-	identity,
-	"udd",
-	[]int{3, 3, 3},
-)
+var onetwo1 = shmeh.Identity(shmeh.IntType, "udd", []int{3, 3, 3})
 
 var s1 = shmeh.NewIntTensor(
 	func(i ...int) int {
@@ -183,16 +166,19 @@ var x2 = shmeh.NewIntTensor(
 )
 
 var det1 = shmeh.NewIntTensor(
-	func(i ...int) int {
-		if i[0] == i[1] {
-			return i[0] + 1
-		}
-		return 0
-	},
+	diagonal,
 	"ud",
 	[]int{3, 3},
 )
 
+// diagonal is 1, 2, 3 down the diagonal.
+func diagonal(i ...int) int {
+	if i[0] == i[1] {
+		return i[0] + 1
+	}
+	return 0
+}
+
 var complex1 = shmeh.NewComplexTensor(
 	func(i ...int) complex128 {
 		z := [][]complex128{
@@ -145,12 +145,12 @@ func main() {
 			0, 0,
 			"Let's demonstrate the IDFT matrix and the DFT matrix are inverses."},
 		{E(
-			newIDFTTensor(9).U("z").D("h"),           // Finally, we invert.
-			newComplexDirac3(9).U("h").D("f").D("g"), // Hadamard product those babies.
-			newDFTTensor(9).U("f").D("a"),            // DFT the first two polynomials.
-			newDFTTensor(9).U("g").D("x"),
+			newIDFTTensor(9).U("z").D("h"),                          // Finally, we invert.
+			newDFTTensor(9).U("h").D("a"),                           // DFT the first two polynomials,
+			newDFTTensor(9).U("h").D("x"),                           // sharing h to Hadamard product those babies.
 			Embed(5, 9).U("a").D("b"), newVec(5, 4, 3, 2, 1).U("b"), //new vector index is a
-			Embed(5, 9).U("x").D("y"), newVec(5, 6, 7, 8, 9).U("y")), //new vector index is x
+			Embed(5, 9).U("x").D("y"), newVec(5, 6, 7, 8, 9).U("y"), //new vector index is x
+		).Generalized(),
 			"u",
 			0, 0,
 			"Finally, we compute the product of the DFT of both polynomials, then invert."},
@@ -255,34 +255,6 @@ var D3 = shmeh.NewIntTensor(
 	[]int{3, 3},
 )
 
-func identity(i ...int) int {
-	val := i[0]
-	for _, elt := range i {
-		if elt != val {
-			return 0
-		}
-	}
-	return 1
-}
-
-var dirac_delta3 = shmeh.NewIntTensor(
-	identity,
-	"uud",
-	[]int{3, 3, 3},
-)
-
-var dirac_delta5 = shmeh.NewIntTensor(
-	identity,
-	"uud",
-	[]int{5, 5, 5},
-)
-
-var dirac_delta9 = shmeh.NewIntTensor(
-	identity,
-	"uud",
-	[]int{9, 9, 9},
-)
-
 // Shift the 0th row 0 to the right, 1st row 1 to the right
 // Shift the nth row n to the right.
 var ProgressiveShift3 = shmeh.NewIntTensor(
@@ -336,14 +308,7 @@ var ProgressiveShift9 = shmeh.NewIntTensor(
 // embed an input a vector in a different vector space.
 // When larger, it zero-pads all new dimensions.
 func Embed(inputDim, outputDim int) *shmeh.Tensor {
-	f := func(i ...int) complex128 {
-		return complex(float64(identity(i...)), 0)
-	}
-	t := shmeh.NewComplexTensor(
-		f,
-		"ud",
-		[]int{outputDim, inputDim},
-	)
+	t := shmeh.Identity(shmeh.ComplexType, "ud", []int{outputDim, inputDim})
 	return &t
 }
 
@@ -373,21 +338,6 @@ func DFT(n int) func(i ...int) complex128 {
 	return f
 }
 
-func newComplexDirac3(size int) *shmeh.Tensor {
-	f := func(i ...int) complex128 {
-		if i[0] == i[1] && i[1] == i[2] {
-			return complex(1., 0.)
-		}
-		return complex(0., 0.)
-	}
-
-	t := shmeh.NewComplexTensor(
-		f,
-		"dud",
-		[]int{size, size, size})
-	return &t
-}
-
 func newDFTTensor(size int) *shmeh.Tensor {
 	t := shmeh.NewComplexTensor(
 		DFT(size),
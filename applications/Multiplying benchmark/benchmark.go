@@ -125,6 +125,27 @@ func main() {
 			"ud",
 			0, 0,
 			"Multiplying the same 2 x 2 matrix 21 times."},
+		{E(twobytwo.U("a").D("b"), twobytwo.U("b").D("c"), twobytwo.U("c").D("d"),
+			twobytwo.U("d").D("e"), twobytwo.U("e").D("f"), twobytwo.U("f").D("g"),
+			twobytwo.U("g").D("h"), twobytwo.U("h").D("i"), twobytwo.U("i").D("j"),
+			twobytwo.U("j").D("k"), twobytwo.U("k").D("l"), twobytwo.U("l").D("m"),
+			twobytwo.U("m").D("n"), twobytwo.U("n").D("o"), twobytwo.U("o").D("p"),
+			twobytwo.U("p").D("q"), twobytwo.U("q").D("r"), twobytwo.U("r").D("s"),
+			twobytwo.U("s").D("t"), twobytwo.U("t").D("u"), twobytwo.U("u").D("v"),
+		).Using(shmeh.RightToLeft),
+			"ud",
+			0, 0,
+			"The same chain, folded right to left the old way."},
+		{E(twobytwo.U("a").D("b"), twobytwo.U("c").D("d"), twobytwo.U("e").D("f"),
+			newVec(1, 2).U("b"), newVec(3, 4).U("d"), newVec(5, 6).U("f")),
+			"uuu",
+			0, 0,
+			"Three matrix vector products written out of order. The planner pairs them up."},
+		{E(twobytwo.U("a").D("b"), twobytwo.U("c").D("d"), twobytwo.U("e").D("f"),
+			newVec(1, 2).U("b"), newVec(3, 4).U("d"), newVec(5, 6).U("f")).Using(shmeh.RightToLeft),
+			"uuu",
+			0, 0,
+			"Folded right to left, the vectors get multiplied together first."},
 	}
 	for _, elt := range table {
 		tensor, err, profiler := elt.t.Eval()
@@ -163,9 +184,9 @@ var twobytwo = shmeh.NewIntTensor(
 
 // New vector helper function.
 func newVec(i ...int) *shmeh.Tensor {
-	t := shmeh.NewComplexTensor(
-		func(j ...int) complex128 {
-			return complex(float64(i[j[0]]), 0)
+	t := shmeh.NewIntTensor(
+		func(j ...int) int {
+			return i[j[0]]
 		},
 		"u",
 		[]int{len(i)})
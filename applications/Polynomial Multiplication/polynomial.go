@@ -104,7 +104,11 @@ func VisualizePolynomial(t shmeh.Tensor, contraLabels, coLabels [][]string) {
 }
 
 func main() {
-	E := shmeh.E
+	// The variances below are only there to lay the polynomials out
+	// on the screen, so contract without checking them.
+	E := func(e ...shmeh.Expression) shmeh.Term {
+		return shmeh.E(e...).Permissive()
+	}
 	table := []struct {
 		t shmeh.Term
 		// Reshape it to make it visually compelling.
@@ -177,7 +181,7 @@ func main() {
 			"ud",
 			0, 0,
 			"Progressive right shift on <1, 2, 3>"},
-		{E(dirac_delta3.U("a").U("b").D("c"), ProgressiveShift3.D("b").U("x").D("y"), elements.U("c").U("y")),
+		{E(ProgressiveShift3.D("a").U("x").D("y"), elements.U("a").U("y")).Generalized().To("ax"),
 			"ud",
 			0, 0,
 			"Progressive right shift on P2"},
@@ -189,22 +193,22 @@ func main() {
 			"ud",
 			0, 0,
 			"Tensor product of (5x^4 + 4x^3 + 3x^2 + 2x + 1) * (5x^4 + 6x^3 + 7x^2 + 8x + 9.)"},
-		{E(dirac_delta5.U("a").U("b").D("c"), ProgressiveShift5.D("b").U("x").D("y"),
-			newVec(5, 4, 3, 2, 1).U("c"), newVec(5, 6, 7, 8, 9).U("y")),
+		{E(ProgressiveShift5.D("a").U("x").D("y"),
+			newVec(5, 4, 3, 2, 1).U("a"), newVec(5, 6, 7, 8, 9).U("y")).Generalized().To("ax"),
 			"ud",
 			0, 0,
 			"Progressive shift the product."},
 		{E(
-			dirac_delta9.U("a").U("b").D("i"), ProgressiveShift9.D("b").U("x").D("l"),
-			Embed(5, 9).U("i").D("j"), newVec(5, 4, 3, 2, 1).U("j"), //new vector index is i
-			Embed(5, 9).U("l").D("z"), newVec(5, 6, 7, 8, 9).U("z")), //new vector index is l
+			ProgressiveShift9.D("a").U("x").D("l"),
+			Embed(5, 9).U("a").D("j"), newVec(5, 4, 3, 2, 1).U("j"), //new vector index is a
+			Embed(5, 9).U("l").D("z"), newVec(5, 6, 7, 8, 9).U("z")).Generalized().To("ax"), //new vector index is l
 			"ud",
 			0, 0,
 			"Progressive shift the product after proper embedding."},
 		{E(newVec(1, 1, 1, 1, 1, 1, 1, 1, 1).U("a"), // All ones is a row-summing tensor.
-			dirac_delta9.U("a").U("b").D("i"), ProgressiveShift9.D("b").U("x").D("l"),
-			Embed(5, 9).U("i").D("j"), newVec(5, 4, 3, 2, 1).U("j"), //new vector index is i
-			Embed(5, 9).U("l").D("z"), newVec(5, 6, 7, 8, 9).U("z")), //new vector index is l
+			ProgressiveShift9.D("a").U("x").D("l"),
+			Embed(5, 9).U("a").D("j"), newVec(5, 4, 3, 2, 1).U("j"), //new vector index is a
+			Embed(5, 9).U("l").D("z"), newVec(5, 6, 7, 8, 9).U("z")).Generalized(), //new vector index is l
 			"u",
 			0, 0,
 			"Finally, convolution = embed the vectors, tensor product, shift it, sum rows."},
@@ -309,34 +313,6 @@ var D3 = shmeh.NewIntTensor(
 	[]int{3, 3},
 )
 
-func identity(i ...int) int {
-	val := i[0]
-	for _, elt := range i {
-		if elt != val {
-			return 0
-		}
-	}
-	return 1
-}
-
-var dirac_delta3 = shmeh.NewIntTensor(
-	identity,
-	"uud",
-	[]int{3, 3, 3},
-)
-
-var dirac_delta5 = shmeh.NewIntTensor(
-	identity,
-	"uud",
-	[]int{5, 5, 5},
-)
-
-var dirac_delta9 = shmeh.NewIntTensor(
-	identity,
-	"uud",
-	[]int{9, 9, 9},
-)
-
 // Shift the 0th row 0 to the right, 1st row 1 to the right
 // Shift the nth row n to the right.
 var ProgressiveShift3 = shmeh.NewIntTensor(
@@ -390,11 +366,7 @@ var ProgressiveShift9 = shmeh.NewIntTensor(
 // embed an input a vector in a different vector space.
 // When larger, it zero-pads all new dimensions.
 func Embed(inputDim, outputDim int) *shmeh.Tensor {
-	t := shmeh.NewIntTensor(
-		identity,
-		"ud",
-		[]int{outputDim, inputDim},
-	)
+	t := shmeh.Identity(shmeh.IntType, "ud", []int{outputDim, inputDim})
 	return &t
 }
 
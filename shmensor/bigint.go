@@ -0,0 +1,212 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Int Tensors wrap around silently when an element outgrows an
+// int, which polynomial coefficients and counting contractions
+// soon do. This file has two ways out: big ints, which never
+// overflow, and Int64Type, which makes Eval return an ErrOverflow
+// instead of wrapping around.
+//
+// A *big.Int can be modified in place, so big ints follow the
+// rules of rationals; see rational.go. BigIntRing allocates every
+// result, the constructors copy what they are handed, and a
+// Function gets a copy of each element.
+
+// BigIntRing is the Ring of *big.Int.
+type BigIntRing struct{}
+
+func (BigIntRing) Multiply(x, y *big.Int) *big.Int {
+	return new(big.Int).Mul(x, y)
+}
+
+func (BigIntRing) Add(x, y *big.Int) *big.Int {
+	return new(big.Int).Add(x, y)
+}
+
+func (BigIntRing) Negate(x *big.Int) *big.Int {
+	return new(big.Int).Neg(x)
+}
+
+func (BigIntRing) Zero() *big.Int {
+	return new(big.Int)
+}
+
+func (BigIntRing) One() *big.Int {
+	return big.NewInt(1)
+}
+
+func (BigIntRing) Equal(x, y *big.Int) bool {
+	return x.Cmp(y) == 0
+}
+
+// Print writes x in decimal, for Tensor.String.
+func (BigIntRing) Print(x *big.Int) string {
+	return x.String()
+}
+
+// Parse reads a big int the way Print writes it.
+func (BigIntRing) Parse(s string) (*big.Int, error) {
+	x, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("big int: can't parse %q", s)
+	}
+	return x, nil
+}
+
+// BigIntType is BigIntRing as a Type, the Type of big int Tensors.
+var BigIntType Type = boxed[*big.Int]{BigIntRing{}}
+
+// NewBigIntTensor makes a lazy big int Tensor. What f returns
+// is copied, so f may go on to modify it.
+func NewBigIntTensor(f func(i ...int) *big.Int, signature string, dim []int) Tensor {
+	return Box(NewTensorOf(BigIntRing{}, copyBigInts(f), signature, dim))
+}
+
+// copyBigInts copies what f returns.
+func copyBigInts(f func(i ...int) *big.Int) func(i ...int) *big.Int {
+	return func(i ...int) *big.Int {
+		return new(big.Int).Set(f(i...))
+	}
+}
+
+// NewDenseBigIntTensor lays a big int tensor over a copy of a
+// flat slice, like NewDenseTensorOf.
+func NewDenseBigIntTensor(data []*big.Int, strides []int, signature string, dim []int) Tensor {
+	return newDense(box(copyBigIntSlice(data)), strides, signature, dim, BigIntType)
+}
+
+func copyBigIntSlice(data []*big.Int) []*big.Int {
+	ret := make([]*big.Int, len(data))
+	for i, x := range data {
+		ret[i] = new(big.Int).Set(x)
+	}
+	return ret
+}
+
+// NewBigIntFunction makes a Function on big ints. f is
+// handed a copy of each element, so it may modify it.
+func NewBigIntFunction(f func(r *big.Int) *big.Int) Function {
+	wrapper := func(i interface{}) interface{} {
+		return f(new(big.Int).Set(i.(*big.Int)))
+	}
+	return Function{
+		wrapper,
+		BigIntType,
+	}
+}
+
+// BigInts returns an int or Int64Type Tensor as a big int one.
+func BigInts(t Tensor) (Tensor, error) {
+	if u, err := Unbox[int](t); err == nil {
+		return Box(convert(u, BigIntRing{}, func(x int) *big.Int {
+			return big.NewInt(int64(x))
+		})), nil
+	}
+	if u, err := Unbox[int64](t); err == nil {
+		return Box(convert(u, BigIntRing{}, big.NewInt)), nil
+	}
+	return Tensor{}, ErrTypeMismatch{Op: "big ints", A: IntType, B: t.t}
+}
+
+// Ints returns a big int Tensor as a dense int one. It reads
+// every element, and returns an ErrOverflow if one is too big
+// for an int.
+func Ints(t Tensor) (Tensor, error) {
+	u, err := Unbox[*big.Int](t)
+	if err != nil {
+		return Tensor{}, ErrTypeMismatch{Op: "ints", A: BigIntType, B: t.t}
+	}
+	ret, err, _ := checked(func() (Tensor, error, *Profiler) {
+		return Box(convert(u, IntRing{}, func(x *big.Int) int {
+			if !x.IsInt64() || x.Int64() > math.MaxInt || x.Int64() < math.MinInt {
+				Fail(ErrOverflow{Op: "ints", Elements: []interface{}{x}})
+			}
+			return int(x.Int64())
+		})).Materialize(), nil, nil
+	})
+	return ret, err
+}
+
+// convert maps f over the elements of t, for a TensorOf
+// elements of r.
+func convert[A, B any](t TensorOf[A], r Ring[B], f func(A) B) TensorOf[B] {
+	g := t.f
+	ret := NewTensorOf(r, func(i ...int) B { return f(g(i...)) }, t.signature, t.dim)
+	ret.workers = t.workers
+	return ret
+}
+
+// Int64Ring is the Ring of int64 that fails with an ErrOverflow
+// where int arithmetic would wrap around. It is a CheckedRing, so
+// Eval reads every element of an Int64Type Tensor, and returns the
+// error.
+type Int64Ring struct{}
+
+func (Int64Ring) Multiply(x, y int64) int64 {
+	p := x * y
+	if x != 0 && (p/x != y || x == -1 && y == math.MinInt64) {
+		Fail(ErrOverflow{Op: "multiply", Elements: []interface{}{x, y}})
+	}
+	return p
+}
+
+func (Int64Ring) Add(x, y int64) int64 {
+	s := x + y
+	if x > 0 && y > 0 && s < 0 || x < 0 && y < 0 && s >= 0 {
+		Fail(ErrOverflow{Op: "add", Elements: []interface{}{x, y}})
+	}
+	return s
+}
+
+func (Int64Ring) Negate(x int64) int64 {
+	if x == math.MinInt64 {
+		Fail(ErrOverflow{Op: "negate", Elements: []interface{}{x}})
+	}
+	return -x
+}
+
+func (Int64Ring) Zero() int64 {
+	return 0
+}
+
+func (Int64Ring) One() int64 {
+	return 1
+}
+
+func (Int64Ring) Equal(x, y int64) bool {
+	return x == y
+}
+
+func (Int64Ring) Checked() {}
+
+// Int64Type is Int64Ring as a Type, the Type of checked int64 Tensors.
+var Int64Type Type = boxed[int64]{Int64Ring{}}
+
+// NewInt64Tensor makes a lazy checked int64 Tensor. Eval of a
+// Term or Sum over it returns an ErrOverflow rather than wrapping.
+func NewInt64Tensor(f func(i ...int) int64, signature string, dim []int) Tensor {
+	return Box(NewTensorOf(Int64Ring{}, f, signature, dim))
+}
+
+// NewDenseInt64Tensor lays a checked int64 tensor over a flat slice,
+// like NewDenseTensorOf.
+func NewDenseInt64Tensor(data []int64, strides []int, signature string, dim []int) Tensor {
+	return newDense(box(data), strides, signature, dim, Int64Type)
+}
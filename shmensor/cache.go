@@ -0,0 +1,105 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"sync"
+)
+
+// Contractions remember the elements they have summed, since the
+// next step of a term usually reads each of them many times. The
+// cache is keyed by the element's position in row major order, so
+// a lookup costs a few multiplies rather than a formatted string,
+// and it holds a bounded number of elements, evicting with the
+// clock algorithm once full.
+
+// DefaultCacheCapacity is how many elements each contraction
+// caches unless a Term says otherwise.
+const DefaultCacheCapacity = 1 << 16
+
+// cache is a fixed capacity map from element offsets to elements.
+// It is safe for concurrent use.
+type cache[T any] struct {
+	mu       sync.Mutex
+	capacity int
+	// Where each cached key lives in keys, values and used.
+	slot   map[int]int
+	keys   []int
+	values []T
+	// Whether each slot was read since the hand last passed it.
+	used []bool
+	hand int
+}
+
+// newCache makes a cache for a tensor of the given size.
+// It returns nil, which caches nothing, if capacity is zero or less.
+func newCache[T any](capacity, size int) *cache[T] {
+	if capacity <= 0 {
+		return nil
+	}
+	n := capacity
+	if size < n {
+		n = size
+	}
+	return &cache[T]{
+		capacity: capacity,
+		slot:     make(map[int]int, n),
+		keys:     make([]int, 0, n),
+		values:   make([]T, 0, n),
+		used:     make([]bool, 0, n),
+	}
+}
+
+func (c *cache[T]) get(key int) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.slot[key]
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	c.used[s] = true
+	return c.values[s], true
+}
+
+// put caches v under key and reports whether
+// it had to evict another element to make room.
+func (c *cache[T]) put(key int, v T) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Another goroutine got here first with the same answer.
+	if s, ok := c.slot[key]; ok {
+		c.values[s] = v
+		return false
+	}
+	if len(c.keys) < c.capacity {
+		c.slot[key] = len(c.keys)
+		c.keys = append(c.keys, key)
+		c.values = append(c.values, v)
+		c.used = append(c.used, false)
+		return false
+	}
+	// Sweep the hand past recently read elements,
+	// giving each a second chance, and evict the first
+	// one nobody read since the last sweep.
+	for c.used[c.hand] {
+		c.used[c.hand] = false
+		c.hand = (c.hand + 1) % c.capacity
+	}
+	delete(c.slot, c.keys[c.hand])
+	c.slot[key] = c.hand
+	c.keys[c.hand] = key
+	c.values[c.hand] = v
+	c.hand = (c.hand + 1) % c.capacity
+	return true
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"fmt"
+)
+
+// This file decides which intermediates of a term Term.Eval
+// materializes. A lazy intermediate is recomputed every time the
+// next step reads one of its elements. Contractions cache their
+// elements, but plain products don't, so an outer product read
+// many times over can cost far more than storing it once.
+
+// Policy decides which intermediates Term.Eval materializes.
+type Policy int
+
+const (
+	// CostBased materializes an intermediate when recomputing it
+	// on every read is estimated to cost more than checkpointThreshold
+	// closure calls per element.
+	CostBased Policy = iota
+	// Lazy never materializes; all work happens in Reify.
+	Lazy
+	// Eager materializes every intermediate and the result.
+	Eager
+)
+
+// Extra closure calls per element worth saving by materializing.
+const checkpointThreshold = 4
+
+func (p Policy) String() string {
+	switch p {
+	case CostBased:
+		return "cost based"
+	case Lazy:
+		return "lazy"
+	case Eager:
+		return "eager"
+	}
+	return "unknown"
+}
+
+// A Checkpoint records whether Term.Eval materialized
+// the result of one step of its plan.
+type Checkpoint struct {
+	// Step is the contraction, like "ab*bc->ac".
+	Step string
+	// Reads is how many times the next step reads each element.
+	Reads int
+	// Cost is the estimated number of closure calls it
+	// takes to recompute one element.
+	Cost         int
+	Materialized bool
+}
+
+func (c Checkpoint) String() string {
+	verdict := "lazy"
+	if c.Materialized {
+		verdict = "materialized"
+	}
+	return fmt.Sprintf("%v read %vx at cost %v: %v", c.Step, c.Reads, c.Cost, verdict)
+}
+
+// checkpoints walks a plan and decides, step by step,
+// whether to materialize what the step produces. Contractions
+// cache up to capacity elements.
+func (p *planner) checkpoints(pl plan, policy Policy, capacity int, name func(int) string) []Checkpoint {
+	n := len(p.operands)
+	groups := make(map[int][]int)
+	cost := make(map[int]int)
+	for i := 0; i < n; i++ {
+		groups[i] = []int{i}
+		cost[i] = 1
+	}
+	// Which step reads each operand.
+	reader := make(map[int]int)
+	for k, s := range pl.steps {
+		reader[s.a] = k
+		reader[s.b] = k
+		groups[n+k] = append(append([]int{}, groups[s.a]...), groups[s.b]...)
+	}
+	// Operands whose elements are already stored, either
+	// densely or in a contraction cache, cost one call to read.
+	stored := make(map[int]bool)
+
+	render := func(labels []int) string {
+		s := ""
+		for _, l := range labels {
+			s += name(l)
+		}
+		return s
+	}
+	var ret []Checkpoint
+	for k, s := range pl.steps {
+		id := n + k
+		fa, fb, out := p.free(groups[s.a]), p.free(groups[s.b]), p.free(groups[id])
+		if k == len(pl.steps)-1 {
+			out = p.order
+		}
+		all, _ := p.cost(fa, fb, out)
+		points := all / p.size(out)
+
+		read := func(o int) int {
+			if stored[o] {
+				return 1
+			}
+			return cost[o]
+		}
+		cost[id] = points * (read(s.a) + read(s.b) + 1)
+		// Contractions cache their elements as they go,
+		// if the cache has room for all of them.
+		stored[id] = points > 1 && capacity >= p.size(out)
+
+		c := Checkpoint{
+			Step:  render(fa) + "*" + render(fb) + "->" + render(out),
+			Reads: 1,
+			Cost:  cost[id],
+		}
+		if k < len(pl.steps)-1 {
+			next := pl.steps[reader[id]]
+			nall, _ := p.cost(p.free(groups[next.a]), p.free(groups[next.b]), nil)
+			c.Reads = nall / p.size(out)
+		}
+		switch policy {
+		case Eager:
+			c.Materialized = true
+		case CostBased:
+			c.Materialized = k < len(pl.steps)-1 && !stored[id] &&
+				(c.Reads-1)*c.Cost >= checkpointThreshold
+		}
+		if c.Materialized {
+			stored[id] = true
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
@@ -0,0 +1,248 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"strings"
+	"time"
+)
+
+// This file holds the one kernel every product, trace and
+// contraction in shmensor goes through. Given some operands, it
+// multiplies them together and sums over a set of indices in a
+// single loop nest, so contracting k indices costs one closure
+// rather than k nested ones.
+
+// fuse builds the lazy tensor
+//
+//	out(i...) = sum over s... of operands[0](...) * operands[1](...) * ...
+//
+// wires[k][j] says where index j of operands[k] gets its coordinate:
+// from output index w when w >= 0, or from summed index ^w when w < 0.
+// sums holds the dimension of every summed index.
+//
+// When there is something to sum, up to capacity elements are
+// cached; see cache.go. The work done is counted into n.
+func fuse[T any](operands []TensorOf[T], wires [][]int, sums []int,
+	signature string, dim []int, capacity int, n *node) TensorOf[T] {
+	r := operands[0].t
+	points := 1
+	for _, d := range sums {
+		points *= d
+	}
+
+	// The cache is shared by every goroutine reading the tensor.
+	// Two of them may compute the same element at once; both
+	// get the same answer, so that is only wasted work.
+	var c *cache[T]
+	if len(sums) > 0 {
+		c = newCache[T](capacity, size(dim))
+	}
+	strides := rowMajor(dim)
+	f := func(i ...int) T {
+		n.calls.Add(1)
+		key := 0
+		if c != nil {
+			for k, x := range i {
+				key += x * strides[k]
+			}
+			if hit, ok := c.get(key); ok {
+				n.hits.Add(1)
+				return hit
+			}
+			n.misses.Add(1)
+		}
+		defer n.elapsed(time.Now())
+		out := make([]int, len(i))
+		copy(out, i)
+
+		coords := make([][]int, len(operands))
+		for k := range operands {
+			coords[k] = make([]int, len(wires[k]))
+		}
+		s := make([]int, len(sums))
+		var sum T
+		var muls, adds int
+		for p := 0; p < points; p++ {
+			for k, w := range wires {
+				for j, x := range w {
+					if x >= 0 {
+						coords[k][j] = out[x]
+					} else {
+						coords[k][j] = s[^x]
+					}
+				}
+			}
+			term := operands[0].f(coords[0]...)
+			for k := 1; k < len(operands); k++ {
+				term = r.Multiply(term, operands[k].f(coords[k]...))
+				muls++
+			}
+			if p == 0 {
+				sum = term
+			} else {
+				sum = r.Add(sum, term)
+				adds++
+			}
+			// Step the summed indices like an odometer.
+			for j := len(s) - 1; j >= 0; j-- {
+				s[j]++
+				if s[j] < sums[j] {
+					break
+				}
+				s[j] = 0
+			}
+		}
+		// A sum over an index of dimension zero has no terms.
+		if points == 0 {
+			sum = r.Zero()
+		}
+		n.multiplies.Add(int64(muls))
+		n.adds.Add(int64(adds))
+		if c != nil && c.put(key, sum) {
+			n.evictions.Add(1)
+		}
+		return sum
+	}
+
+	return TensorOf[T]{
+		f:         f,
+		signature: signature,
+		dim:       dim,
+		t:         r,
+	}
+}
+
+// Contract multiplies two expressions together and sums over
+// every index they repeat, in one pass. The free indices of
+// the result are those of a followed by those of b.
+//
+// Mat multiply example
+// Contract(m.U("i").D("j"), v.U("j"), nil)
+func Contract[T any](a, b ExpressionOf[T], profiler *Profiler) (ExpressionOf[T], error) {
+	count := make(map[string]int)
+	all := append(append([]string{}, a.indices...), b.indices...)
+	for _, ch := range all {
+		count[ch]++
+	}
+	out := []string{}
+	for _, ch := range all {
+		switch count[ch] {
+		case 1:
+			out = append(out, ch)
+		case 2:
+		default:
+			return ExpressionOf[T]{}, ErrIndexRepeated{Index: ch, Count: count[ch]}
+		}
+	}
+	return contract([]ExpressionOf[T]{a, b}, out, DefaultCacheCapacity, profiler)
+}
+
+// contract multiplies the expressions together and sums over every
+// index not in out. The result has the indices of out, in order,
+// and caches up to capacity of its elements.
+func contract[T any](es []ExpressionOf[T], out []string, capacity int, profiler *Profiler) (ExpressionOf[T], error) {
+	// Number the summed indices and find every dimension.
+	sumOf := make(map[string]int)
+	dimOf := make(map[string]int)
+	var sums []int
+	for _, e := range es {
+		if len(e.indices) != len(e.t.dim) {
+			return ExpressionOf[T]{}, ErrSignatureMismatch{Op: "contract",
+				Index: render(e.indices), Signature: e.signature, Want: e.t.signature}
+		}
+		if err := e.permutation(); err != nil {
+			return ExpressionOf[T]{}, err
+		}
+		for j, ch := range e.indices {
+			d, ok := dimOf[ch]
+			if ok && d != e.t.dim[e.slot(j)] {
+				return ExpressionOf[T]{}, ErrDimensionMismatch{Op: "contract",
+					Index: ch, A: []int{d}, B: []int{e.t.dim[e.slot(j)]}}
+			}
+			dimOf[ch] = e.t.dim[e.slot(j)]
+			if _, ok := sumOf[ch]; !ok && indexOf(out, ch) < 0 {
+				sumOf[ch] = len(sums)
+				sums = append(sums, e.t.dim[e.slot(j)])
+			}
+		}
+	}
+
+	ret := ExpressionOf[T]{indices: out}
+	var sig string
+	dim := make([]int, len(out))
+	for k := 0; k < len(out); k++ {
+		if _, ok := dimOf[out[k]]; !ok {
+			return ExpressionOf[T]{}, ErrOutputMismatch{Output: render(out), Free: render(freeOf(es))}
+		}
+		dim[k] = dimOf[out[k]]
+	}
+	// Output signatures come from the first place each index shows up.
+	for k := 0; k < len(out); k++ {
+		for _, e := range es {
+			if j := indexOf(e.indices, out[k]); j >= 0 {
+				sig += string(e.t.signature[e.slot(j)])
+				ret.signature += string(e.signature[j])
+				break
+			}
+		}
+	}
+
+	// A lone expression with nothing to sum or move is left alone.
+	if len(es) == 1 && es[0].slots == nil && sameLabels(es[0].indices, out) {
+		return es[0], nil
+	}
+
+	operands := make([]TensorOf[T], len(es))
+	wires := make([][]int, len(es))
+	for k, e := range es {
+		operands[k] = *e.t
+		wires[k] = make([]int, len(e.indices))
+		for j, ch := range e.indices {
+			if x := indexOf(out, ch); x >= 0 {
+				wires[k][e.slot(j)] = x
+			} else {
+				wires[k][e.slot(j)] = ^sumOf[ch]
+			}
+		}
+	}
+	// Name the node after the expressions, like "^a_b*^b_c->^a_c".
+	var name []string
+	for _, e := range es {
+		name = append(name, e.String())
+	}
+	n := profiler.node(strings.Join(name, "*")+"->"+ret.String(), OpContract, size(dim))
+	t := fuse(operands, wires, sums, sig, dim, capacity, n)
+	ret.t = &t
+	return ret, nil
+}
+
+// freeOf returns the labels that show up once in es, in the
+// order written.
+func freeOf[T any](es []ExpressionOf[T]) []string {
+	count := make(map[string]int)
+	for _, e := range es {
+		for _, ch := range e.indices {
+			count[ch]++
+		}
+	}
+	ret := []string{}
+	for _, e := range es {
+		for _, ch := range e.indices {
+			if count[ch] == 1 {
+				ret = append(ret, ch)
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"fmt"
+)
+
+// Tensors are lazy: a chain of products, traces and applications
+// is a tree of closures, and every Reify walks the whole tree again
+// for every element. A dense Tensor is evaluated once into a flat
+// slice, and its coordinate function is just stride arithmetic.
+// Dense and lazy tensors share the Tensor type, so they mix freely.
+
+// storage is the contiguous backing of a dense Tensor.
+// Element (i, j, ...) lives at data[i*strides[0] + j*strides[1] + ...].
+type storage[T any] struct {
+	data    []T
+	strides []int
+}
+
+func (s *storage[T]) at(i ...int) T {
+	offset := 0
+	for k, x := range i {
+		offset += x * s.strides[k]
+	}
+	return s.data[offset]
+}
+
+// rowMajor gives the strides of a tightly packed tensor
+// whose last index varies fastest.
+func rowMajor(dim []int) []int {
+	strides := make([]int, len(dim))
+	stride := 1
+	for k := len(dim) - 1; k >= 0; k-- {
+		strides[k] = stride
+		stride *= dim[k]
+	}
+	return strides
+}
+
+// newDense wraps data in a Tensor. Nil strides mean row major.
+// It panics if some coordinate would fall outside of data, so
+// that a bad layout fails here rather than deep inside a Reify.
+func newDense[T any](data []T, strides []int, signature string, dim []int, t Ring[T]) TensorOf[T] {
+	if strides == nil {
+		strides = rowMajor(dim)
+	}
+	if len(strides) != len(dim) || len(signature) != len(dim) {
+		panic(fmt.Sprintf("dense tensor has %v strides and signature %q for %v indices",
+			len(strides), signature, len(dim)))
+	}
+	for _, x := range strides {
+		if x < 0 {
+			panic(fmt.Sprintf("dense tensor has negative strides %v", strides))
+		}
+	}
+	last := 0
+	for k, d := range dim {
+		if d == 0 {
+			last = -1
+			break
+		}
+		last += (d - 1) * strides[k]
+	}
+	if last >= len(data) {
+		panic(fmt.Sprintf("dense tensor of dimension %v and strides %v needs %v elements, got %v",
+			dim, strides, last+1, len(data)))
+	}
+	s := &storage[T]{data, strides}
+	return TensorOf[T]{
+		f:         s.at,
+		signature: signature,
+		dim:       dim,
+		t:         t,
+		s:         s,
+	}
+}
+
+// Dense reports whether the Tensor is backed by storage
+// rather than a lazy closure.
+func (t TensorOf[T]) Dense() bool {
+	return t.s != nil
+}
+
+// Materialize evaluates every element of the Tensor once and
+// returns a dense Tensor holding the results. Dense tensors are
+// returned as they are.
+func (t TensorOf[T]) Materialize() TensorOf[T] {
+	if t.s != nil {
+		return t
+	}
+	size := 1
+	for _, d := range t.dim {
+		size *= d
+	}
+	data := make([]T, size)
+	split(size, t.workers, func(lo, hi int) {
+		i := giveCoordinate(t.dim, lo)
+		for n := lo; n < hi; n++ {
+			data[n] = t.f(i...)
+			for k := len(i) - 1; k >= 0; k-- {
+				i[k]++
+				if i[k] < t.dim[k] {
+					break
+				}
+				i[k] = 0
+			}
+		}
+	})
+	dim := make([]int, len(t.dim))
+	copy(dim, t.dim)
+	dense := newDense(data, nil, t.signature, dim, t.t)
+	dense.workers = t.workers
+	return dense
+}
@@ -0,0 +1,243 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Errors shmensor returns for bad input. Callers can use errors.As
+// to find out what went wrong and with which indices, or errors.Is
+// with a zero value, like
+//
+//	errors.Is(err, ErrDimensionMismatch{})
+//
+// to just ask what kind of thing went wrong. Plus and Apply wrap
+// the errors of the Evaluators under them, so both work on the
+// error from the top of an Evaluator tree.
+
+// ErrDimensionMismatch is returned when two indices, or two
+// tensors, need to have the same dimension but don't.
+type ErrDimensionMismatch struct {
+	// Op is what was being done, like "trace".
+	Op string
+	// Index is the label of the offending index, if it has one.
+	Index string
+	A, B  []int
+}
+
+func (e ErrDimensionMismatch) Error() string {
+	if e.Index != "" {
+		return fmt.Sprintf("%v: index %v has incompatible dimensions %v and %v",
+			e.Op, e.Index, e.A, e.B)
+	}
+	return fmt.Sprintf("%v: incompatible dimensions %v and %v", e.Op, e.A, e.B)
+}
+
+// Is reports whether target is an ErrDimensionMismatch.
+func (e ErrDimensionMismatch) Is(target error) bool {
+	_, ok := target.(ErrDimensionMismatch)
+	return ok
+}
+
+// ErrSignatureMismatch is returned when a signature doesn't
+// fit the tensor it is given for.
+type ErrSignatureMismatch struct {
+	Op string
+	// Index holds the labels of the offending indices, if they have any.
+	Index string
+	// Signature is what was given, Want the signature
+	// of the tensor it was given for.
+	Signature, Want string
+}
+
+func (e ErrSignatureMismatch) Error() string {
+	if e.Index != "" {
+		return fmt.Sprintf("%v: signature %q of indices %v does not fit a tensor of signature %q",
+			e.Op, e.Signature, e.Index, e.Want)
+	}
+	return fmt.Sprintf("%v: signature %q does not fit a tensor of signature %q",
+		e.Op, e.Signature, e.Want)
+}
+
+// Is reports whether target is an ErrSignatureMismatch.
+func (e ErrSignatureMismatch) Is(target error) bool {
+	_, ok := target.(ErrSignatureMismatch)
+	return ok
+}
+
+// ErrTypeMismatch is returned when tensors, or a tensor and
+// a function, with elements of different types meet.
+type ErrTypeMismatch struct {
+	Op string
+	// A and B are the Rings of the elements, or for
+	// Scaled, the coefficient and the Ring.
+	A, B interface{}
+}
+
+func (e ErrTypeMismatch) Error() string {
+	return fmt.Sprintf("%v: incompatible types %v and %v",
+		e.Op, reflect.TypeOf(e.A), reflect.TypeOf(e.B))
+}
+
+// Is reports whether target is an ErrTypeMismatch.
+func (e ErrTypeMismatch) Is(target error) bool {
+	_, ok := target.(ErrTypeMismatch)
+	return ok
+}
+
+// ErrVarianceMismatch is returned when a strict Term sums over
+// an index that is up in both places it appears, or down in both.
+type ErrVarianceMismatch struct {
+	Index string
+	// A and B are the two Expressions, like "^ij".
+	A, B string
+}
+
+func (e ErrVarianceMismatch) Error() string {
+	return fmt.Sprintf("index %v has the same variance in %v and %v", e.Index, e.A, e.B)
+}
+
+// Is reports whether target is an ErrVarianceMismatch.
+func (e ErrVarianceMismatch) Is(target error) bool {
+	_, ok := target.(ErrVarianceMismatch)
+	return ok
+}
+
+// ErrIndexRepeated is returned when an index label shows up
+// more than twice in a term.
+type ErrIndexRepeated struct {
+	Index string
+	Count int
+}
+
+func (e ErrIndexRepeated) Error() string {
+	return fmt.Sprintf("index %v repeated %v times, more than twice", e.Index, e.Count)
+}
+
+// Is reports whether target is an ErrIndexRepeated.
+func (e ErrIndexRepeated) Is(target error) bool {
+	_, ok := target.(ErrIndexRepeated)
+	return ok
+}
+
+// ErrOutputMismatch is returned when the indices declared with
+// Term.To are not the free indices of the term, each once.
+type ErrOutputMismatch struct {
+	// Output is what was declared, Free the free
+	// indices of the term in the order written.
+	Output, Free string
+}
+
+func (e ErrOutputMismatch) Error() string {
+	return fmt.Sprintf("output indices %q are not the free indices %q", e.Output, e.Free)
+}
+
+// Is reports whether target is an ErrOutputMismatch.
+func (e ErrOutputMismatch) Is(target error) bool {
+	_, ok := target.(ErrOutputMismatch)
+	return ok
+}
+
+// ErrIndexMismatch is returned when the terms of a sum
+// don't have the same free indices.
+type ErrIndexMismatch struct {
+	Op string
+	// A holds the free indices of the first term, B those of the other.
+	A, B string
+}
+
+func (e ErrIndexMismatch) Error() string {
+	return fmt.Sprintf("%v: indices %q do not match %q", e.Op, e.B, e.A)
+}
+
+// Is reports whether target is an ErrIndexMismatch.
+func (e ErrIndexMismatch) Is(target error) bool {
+	_, ok := target.(ErrIndexMismatch)
+	return ok
+}
+
+// ErrEmptySum is returned by a Sum with no terms, which has
+// no shape to give its zero.
+type ErrEmptySum struct {
+	Op string
+}
+
+func (e ErrEmptySum) Error() string {
+	return fmt.Sprintf("%v: no terms", e.Op)
+}
+
+// Is reports whether target is an ErrEmptySum.
+func (e ErrEmptySum) Is(target error) bool {
+	_, ok := target.(ErrEmptySum)
+	return ok
+}
+
+// ErrIndexOutOfRange is returned when an index position
+// is past the rank of the tensor.
+type ErrIndexOutOfRange struct {
+	Op       string
+	Position int
+	Rank     int
+}
+
+func (e ErrIndexOutOfRange) Error() string {
+	return fmt.Sprintf("%v: index %v out of range for a tensor of rank %v",
+		e.Op, e.Position, e.Rank)
+}
+
+// Is reports whether target is an ErrIndexOutOfRange.
+func (e ErrIndexOutOfRange) Is(target error) bool {
+	_, ok := target.(ErrIndexOutOfRange)
+	return ok
+}
+
+// ErrRingLaw is returned by CheckRing when the
+// elements of a Ring break one of the ring laws.
+type ErrRingLaw struct {
+	// Law is the law broken, like "commutativity of Add".
+	Law string
+	// Elements are the a, b and c it was broken for.
+	Elements []interface{}
+}
+
+func (e ErrRingLaw) Error() string {
+	return fmt.Sprintf("%v fails for elements %v", e.Law, e.Elements)
+}
+
+// Is reports whether target is an ErrRingLaw.
+func (e ErrRingLaw) Is(target error) bool {
+	_, ok := target.(ErrRingLaw)
+	return ok
+}
+
+// ErrOverflow is returned when a result doesn't fit its type, like
+// a sum of Int64Type elements past the largest int64, or a big int
+// converted by Ints that is too big for an int.
+type ErrOverflow struct {
+	// Op is what was being done, like "multiply".
+	Op string
+	// Elements are what it was done to.
+	Elements []interface{}
+}
+
+func (e ErrOverflow) Error() string {
+	return fmt.Sprintf("%v: overflow on elements %v", e.Op, e.Elements)
+}
+
+// Is reports whether target is an ErrOverflow.
+func (e ErrOverflow) Is(target error) bool {
+	_, ok := target.(ErrOverflow)
+	return ok
+}
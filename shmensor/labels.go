@@ -0,0 +1,113 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Index labels are tokens rather than bytes. The strings handed to
+// U, D and To are split into labels like this:
+//
+//	"ijk"          i, j, k
+//	"μν"           μ, ν
+//	"i1j2"         i1, j2: a letter takes the digits after it
+//	"{alpha}j"     alpha, j: braces make one label
+//	"alpha, beta"  alpha, beta: with spaces or commas, they separate
+//
+// so U("ij") means what it always has.
+
+// tokens splits a string of indices into labels.
+func tokens(s string) []string {
+	var ret []string
+	if strings.ContainsAny(s, " \t\n,") {
+		for _, f := range strings.FieldsFunc(s, func(r rune) bool {
+			return unicode.IsSpace(r) || r == ','
+		}) {
+			if strings.HasPrefix(f, "{") && strings.HasSuffix(f, "}") {
+				f = f[1 : len(f)-1]
+			}
+			ret = append(ret, f)
+		}
+		return ret
+	}
+	for len(s) > 0 {
+		r, n := utf8.DecodeRuneInString(s)
+		switch {
+		case r == '{':
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				end = len(s)
+			}
+			ret = append(ret, s[1:end])
+			n = end + 1
+			if n > len(s) {
+				n = len(s)
+			}
+		case unicode.IsLetter(r):
+			for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+				n++
+			}
+			ret = append(ret, s[:n])
+		default:
+			ret = append(ret, s[:n])
+		}
+		s = s[n:]
+	}
+	return ret
+}
+
+// render writes labels back out so that tokens reads them
+// the same way, like "ij{alpha}".
+func render(labels []string) string {
+	var ret string
+	for _, l := range labels {
+		ret += renderLabel(l)
+	}
+	return ret
+}
+
+func renderLabel(l string) string {
+	r, n := utf8.DecodeRuneInString(l)
+	if n == len(l) && r != '{' && r != ',' && !unicode.IsSpace(r) {
+		return l
+	}
+	if unicode.IsLetter(r) && strings.Trim(l[n:], "0123456789") == "" {
+		return l
+	}
+	return "{" + l + "}"
+}
+
+// indexOf is the position of label l in labels, or -1.
+func indexOf(labels []string, l string) int {
+	for k, x := range labels {
+		if x == l {
+			return k
+		}
+	}
+	return -1
+}
+
+func sameLabels(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import "fmt"
+
+// Index juggling. Summing an upper index against a lower one needs
+// nothing but the components; summing two upper indices, or two
+// lower ones, needs a metric to say how. Given one, a Term rewrites
+//
+//	v^i w^i    as    v^i g_ij w^j
+//	a_i b_i    as    a_i g^ij b_j
+//
+// and Raise and Lower move single slots up and down.
+
+// MetricOf is a symmetric rank 2 Tensor g_ij together
+// with its inverse g^ij.
+type MetricOf[T any] struct {
+	g, inverse TensorOf[T]
+}
+
+type Metric = MetricOf[interface{}]
+
+// NewMetric pairs a metric of signature "dd" with its inverse
+// of signature "uu". Both must be square and of the same dimension.
+// That g is symmetric and inverse its inverse is up to the caller.
+func NewMetric[T any](g, inverse TensorOf[T]) (MetricOf[T], error) {
+	if g.signature != "dd" {
+		return MetricOf[T]{}, ErrSignatureMismatch{Op: "metric", Signature: g.signature, Want: "dd"}
+	}
+	if inverse.signature != "uu" {
+		return MetricOf[T]{}, ErrSignatureMismatch{Op: "metric", Signature: inverse.signature, Want: "uu"}
+	}
+	if g.dim[0] != g.dim[1] || inverse.dim[0] != inverse.dim[1] || g.dim[0] != inverse.dim[0] {
+		return MetricOf[T]{}, ErrDimensionMismatch{Op: "metric", A: g.dim, B: inverse.dim}
+	}
+	return MetricOf[T]{g, inverse}, nil
+}
+
+// G returns the metric g_ij.
+func (m MetricOf[T]) G() TensorOf[T] {
+	return m.g
+}
+
+// Inverse returns the inverse metric g^ij.
+func (m MetricOf[T]) Inverse() TensorOf[T] {
+	return m.inverse
+}
+
+// Raise turns the lower index in slot of t into an upper one,
+// contracting it with g^ij. The other slots stay where they are.
+func (m MetricOf[T]) Raise(t TensorOf[T], slot int) (TensorOf[T], error) {
+	return m.juggle("raise", t, slot, 'd')
+}
+
+// Lower turns the upper index in slot of t into a lower one,
+// contracting it with g_ij. The other slots stay where they are.
+func (m MetricOf[T]) Lower(t TensorOf[T], slot int) (TensorOf[T], error) {
+	return m.juggle("lower", t, slot, 'u')
+}
+
+func (m MetricOf[T]) juggle(op string, t TensorOf[T], slot int, from byte) (TensorOf[T], error) {
+	if slot < 0 || slot >= len(t.dim) {
+		return TensorOf[T]{}, ErrIndexOutOfRange{Op: op, Position: slot, Rank: len(t.dim)}
+	}
+	if t.signature[slot] != from {
+		want := []byte(t.signature)
+		want[slot] = from
+		return TensorOf[T]{}, ErrSignatureMismatch{Op: op, Signature: t.signature, Want: string(want)}
+	}
+	// Label the slots of t, and one more for the new index.
+	labels := make([]string, len(t.dim)+1)
+	for k := range labels {
+		labels[k] = fmt.Sprintf("i%v", k)
+	}
+	fresh := labels[len(t.dim)]
+	e := ExpressionOf[T]{t: &t}
+	for k := range t.dim {
+		if t.signature[k] == 'u' {
+			e = e.U(labels[k])
+		} else {
+			e = e.D(labels[k])
+		}
+	}
+	g := m.inverse
+	juggler := g.U(fresh + labels[slot])
+	if from == 'u' {
+		g = m.g
+		juggler = g.D(fresh + labels[slot])
+	}
+	out := labels[:len(t.dim)]
+	out[slot] = fresh
+	r, err, _ := TermOf[T]{List: []ExpressionOf[T]{e, juggler}}.To(render(out)).Eval()
+	return r, err
+}
+
+// insert rewrites a list of Expressions so that every index summed
+// against another of the same variance is summed through the metric
+// instead. The second of the two is renamed to a label the list
+// doesn't use. Indices in output are kept, not summed.
+func (m MetricOf[T]) insert(list []ExpressionOf[T], output []string) []ExpressionOf[T] {
+	used := make(map[string]bool)
+	count := make(map[string]int)
+	for _, e := range list {
+		for _, ch := range e.indices {
+			used[ch] = true
+			count[ch]++
+		}
+	}
+	fresh := func() string {
+		for b := 0; ; b++ {
+			ch := fmt.Sprintf("g%v", b)
+			if !used[ch] {
+				used[ch] = true
+				return ch
+			}
+		}
+	}
+
+	// The variance of the first occurrence of each summed index.
+	first := make(map[string]byte)
+	ret := make([]ExpressionOf[T], 0, len(list))
+	var metrics []ExpressionOf[T]
+	for _, e := range list {
+		indices := append([]string{}, e.indices...)
+		for j, ch := range indices {
+			if count[ch] != 2 || indexOf(output, ch) >= 0 {
+				continue
+			}
+			v, ok := first[ch]
+			if !ok {
+				first[ch] = e.signature[j]
+				continue
+			}
+			if v != e.signature[j] {
+				continue
+			}
+			renamed := fresh()
+			indices[j] = renamed
+			pair := []string{ch, renamed}
+			if v == 'u' {
+				g := m.g
+				metrics = append(metrics, ExpressionOf[T]{t: &g, indices: pair, signature: "dd"})
+			} else {
+				inverse := m.inverse
+				metrics = append(metrics, ExpressionOf[T]{t: &inverse, indices: pair, signature: "uu"})
+			}
+		}
+		e.indices = indices
+		ret = append(ret, e)
+	}
+	return append(ret, metrics...)
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import "fmt"
+
+// Evaluators other than Tensors take indices too, so a sum, a
+// function application or a whole term can be a factor of a Term
+// without evaluating it first:
+//
+//	E(Apply{sigmoid, Plus{E(w.U("i").D("j"), x.U("j")), b}}.U("i"), v.D("i"))
+//
+// Term.Eval evaluates nested Evaluators before it contracts, and
+// passes their errors and Profilers up. The indices label the slots
+// of the result of the nested Evaluator, whatever labels it used
+// inside.
+
+func (term TermOf[T]) U(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{e: term}.U(indices)
+}
+
+func (term TermOf[T]) D(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{e: term}.D(indices)
+}
+
+func (ps PlusOf[T]) U(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{e: ps}.U(indices)
+}
+
+func (ps PlusOf[T]) D(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{e: ps}.D(indices)
+}
+
+func (s SumOf[T]) U(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{e: s}.U(indices)
+}
+
+func (s SumOf[T]) D(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{e: s}.D(indices)
+}
+
+func (as ApplyOf[T]) U(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{e: as}.U(indices)
+}
+
+func (as ApplyOf[T]) D(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{e: as}.D(indices)
+}
+
+func (s ScaledOf[T]) U(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{e: s}.U(indices)
+}
+
+func (s ScaledOf[T]) D(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{e: s}.D(indices)
+}
+
+func (n NegatedOf[T]) U(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{e: n}.U(indices)
+}
+
+func (n NegatedOf[T]) D(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{e: n}.D(indices)
+}
+
+// resolve evaluates the nested Evaluators in list that match,
+// and returns the list with each of their Expressions on a Tensor.
+func resolve[T any](list []ExpressionOf[T], match func(EvaluatorOf[T]) bool, profiler *Profiler) ([]ExpressionOf[T], error) {
+	var ret []ExpressionOf[T]
+	for k, e := range list {
+		if e.e == nil || !match(e.e) {
+			continue
+		}
+		if ret == nil {
+			ret = append([]ExpressionOf[T]{}, list...)
+		}
+		t, err, p := e.e.Eval()
+		profiler.adopt(p)
+		if err != nil {
+			return nil, fmt.Errorf("term: expression %v: %w", k+1, err)
+		}
+		ret[k] = e.settle(t)
+	}
+	if ret == nil {
+		return list, nil
+	}
+	return ret, nil
+}
+
+// every matches every Evaluator.
+func every[T any](EvaluatorOf[T]) bool {
+	return true
+}
+
+// opaque matches the Evaluators that can only give their Shape
+// by evaluating: those that aren't Shapers, and those with one
+// anywhere under them.
+func opaque[T any](e EvaluatorOf[T]) bool {
+	switch e := e.(type) {
+	case TensorOf[T]:
+		return false
+	case ExpressionOf[T]:
+		return e.t == nil && e.e != nil && opaque(e.e)
+	case TermOf[T]:
+		for _, x := range e.List {
+			if opaque[T](x) {
+				return true
+			}
+		}
+		return false
+	case PlusOf[T]:
+		return opaque(e.A) || opaque(e.B)
+	case SumOf[T]:
+		for _, x := range e {
+			if opaque(x) {
+				return true
+			}
+		}
+		return false
+	case ApplyOf[T]:
+		return opaque(e.E)
+	case ScaledOf[T]:
+		return opaque(e.E)
+	case NegatedOf[T]:
+		return opaque(e.E)
+	}
+	_, ok := e.(ShaperOf[T])
+	return !ok
+}
+
+// settle puts the Expression on t, the result of its Evaluator,
+// declaring any slot orders now that there are slots.
+func (e ExpressionOf[T]) settle(t TensorOf[T]) ExpressionOf[T] {
+	r := ExpressionOf[T]{t: &t, indices: e.indices, signature: e.signature, slots: e.slots}
+	for _, slots := range e.pending {
+		r = r.I(slots...)
+	}
+	return r
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Reify and Materialize visit every element of a tensor, and for
+// big tensors that is where all the time goes. Elements don't depend
+// on each other, so the visits can be split across goroutines.
+//
+// Everything shmensor builds is safe to read concurrently. The
+// coordinate functions you hand to the constructors must be too.
+
+// Parallel returns the tensor set to Reify and Materialize
+// with the given number of goroutines. Zero or fewer means one
+// per available CPU. Tensors start out with one.
+func (t TensorOf[T]) Parallel(workers int) TensorOf[T] {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	t.workers = workers
+	return t
+}
+
+// Parallel returns the term set to materialize its intermediates,
+// and to hand back a result that reifies, with the given number of
+// goroutines. See Tensor.Parallel.
+func (term TermOf[T]) Parallel(workers int) TermOf[T] {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	term.workers = workers
+	return term
+}
+
+// split hands out [0, n) in contiguous chunks to at most
+// workers goroutines and waits for them all to finish. If any
+// of them panics, split panics with the same value once they
+// have, so a Fail comes out where Eval can catch it.
+func split(n, workers int, do func(lo, hi int)) {
+	if n <= 0 {
+		return
+	}
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		do(0, n)
+		return
+	}
+	var wg sync.WaitGroup
+	var once sync.Once
+	var panicked interface{}
+	chunk := (n + workers - 1) / workers
+	for lo := 0; lo < n; lo += chunk {
+		hi := lo + chunk
+		if hi > n {
+			hi = n
+		}
+		wg.Add(1)
+		go func(lo, hi int) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					once.Do(func() { panicked = r })
+				}
+			}()
+			do(lo, hi)
+		}(lo, hi)
+	}
+	wg.Wait()
+	if panicked != nil {
+		panic(panicked)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This file turns Einstein notation written out as a string into
+// the Evaluators you would otherwise build by hand. The grammar is
+//
+//	notation = sum [ "->" labels ]
+//	sum      = term { "+" term }
+//	term     = factor { factor } | name "(" sum ")"
+//	factor   = name { ("^" | "_") indices }
+//	indices  = label | "{" labels "}"
+//	label    = letter { digit }
+//
+// where juxtaposed factors are multiplied, "^" raises indices like
+// Expression.U and "_" lowers them like Expression.D, and labels are
+// read the way U and D read them, so "^{ij}", "^{μν}" and
+// "^{alpha beta}" all hold two. Spaces may separate factors and terms
+// but not a name from its indices. A "->" suffix declares the output
+// indices of every term, like Term.To.
+
+// Notation holds the names a string in Einstein notation can use.
+type Notation struct {
+	Tensors   map[string]Tensor
+	Functions map[string]Function
+}
+
+// Parse is Notation{Tensors: tensors}.Parse(s).
+//
+// Cross product example
+// Parse("eps_{ijk} v^j w^k", map[string]Tensor{"eps": eps, "v": v, "w": w})
+func Parse(s string, tensors map[string]Tensor) (Evaluator, error) {
+	return Notation{Tensors: tensors}.Parse(s)
+}
+
+// Parse builds the Term, Plus and Apply tree that s describes.
+// Sums of more than two terms nest to the left. Malformed strings
+// and unknown names give a *SyntaxError.
+//
+// Like
+// Notation{Tensors: tensors, Functions: functions}.Parse("sigma(W^i_j x^j + b^i)")
+func (n Notation) Parse(s string) (Evaluator, error) {
+	p := &parser{n: n, s: []rune(s)}
+	e, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() == '-' {
+		if p.pos+1 == len(p.s) || p.s[p.pos+1] != '>' {
+			return nil, p.errorf("expected ->")
+		}
+		p.pos += 2
+		p.peek()
+		out := string(p.s[p.pos:])
+		if err := p.labels(out, p.pos); err != nil {
+			return nil, err
+		}
+		return to(e, out), nil
+	}
+	if p.peek() != 0 {
+		return nil, p.errorf("unexpected %q", p.s[p.pos])
+	}
+	return e, nil
+}
+
+// to declares the output indices of every Term in e.
+func to(e Evaluator, indices string) Evaluator {
+	switch e := e.(type) {
+	case Term:
+		return e.To(indices)
+	case Plus:
+		return Plus{to(e.A, indices), to(e.B, indices)}
+	case Apply:
+		return Apply{e.Func, to(e.E, indices)}
+	}
+	return e
+}
+
+// SyntaxError reports what is wrong with a string in
+// Einstein notation and where.
+type SyntaxError struct {
+	// Column counts runes from 1.
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("column %v: %v", e.Column, e.Msg)
+}
+
+type parser struct {
+	n   Notation
+	s   []rune
+	pos int
+}
+
+func (p *parser) errorf(format string, a ...interface{}) error {
+	return &SyntaxError{p.pos + 1, fmt.Sprintf(format, a...)}
+}
+
+// peek skips spaces and returns the next rune, or 0 at the end.
+func (p *parser) peek() rune {
+	for p.pos < len(p.s) && unicode.IsSpace(p.s[p.pos]) {
+		p.pos++
+	}
+	if p.pos == len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) sum() (Evaluator, error) {
+	e, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == '+' {
+		p.pos++
+		b, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		e = Plus{e, b}
+	}
+	return e, nil
+}
+
+func (p *parser) term() (Evaluator, error) {
+	var list []Expression
+	for {
+		switch p.peek() {
+		case 0, '+', '-', ')':
+			if list == nil {
+				return nil, p.errorf("expected a term")
+			}
+			return E(list...), nil
+		}
+		start := p.pos
+		name := p.name()
+		if name == "" {
+			return nil, p.errorf("expected a name, got %q", p.s[p.pos])
+		}
+		if p.pos < len(p.s) && p.s[p.pos] == '(' {
+			if list != nil {
+				p.pos = start
+				return nil, p.errorf("can't multiply by the function %v", name)
+			}
+			return p.apply(name, start)
+		}
+		t, ok := p.n.Tensors[name]
+		if !ok {
+			p.pos = start
+			return nil, p.errorf("unknown tensor %v", name)
+		}
+		e, err := p.indices(Expression{t: &t})
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+}
+
+// apply parses the argument of the function name,
+// which started at start, up to the closing parenthesis.
+func (p *parser) apply(name string, start int) (Evaluator, error) {
+	f, ok := p.n.Functions[name]
+	if !ok {
+		p.pos = start
+		return nil, p.errorf("unknown function %v", name)
+	}
+	p.pos++
+	e, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != ')' {
+		return nil, p.errorf("expected )")
+	}
+	p.pos++
+	switch p.peek() {
+	case 0, '+', '-', ')':
+		return Apply{f, e}, nil
+	}
+	return nil, p.errorf("can't multiply by the function %v", name)
+}
+
+// name reads a letter followed by letters and digits.
+func (p *parser) name() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		r := p.s[p.pos]
+		if !unicode.IsLetter(r) && (p.pos == start || !unicode.IsDigit(r)) {
+			break
+		}
+		p.pos++
+	}
+	return string(p.s[start:p.pos])
+}
+
+// indices reads the "^" and "_" groups following a name onto e.
+func (p *parser) indices(e Expression) (Expression, error) {
+	for p.pos < len(p.s) {
+		var variance func(Expression, string) Expression
+		switch p.s[p.pos] {
+		case '^':
+			variance = Expression.U
+		case '_':
+			variance = Expression.D
+		default:
+			return e, nil
+		}
+		p.pos++
+		if p.pos < len(p.s) && p.s[p.pos] == '{' {
+			p.pos++
+			start := p.pos
+			for depth := 0; p.pos < len(p.s) && (depth > 0 || p.s[p.pos] != '}'); p.pos++ {
+				switch p.s[p.pos] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+			}
+			if p.pos == len(p.s) {
+				return e, p.errorf("expected }")
+			}
+			group := string(p.s[start:p.pos])
+			if len(tokens(group)) == 0 {
+				return e, p.errorf("expected an index")
+			}
+			if err := p.labels(group, start); err != nil {
+				return e, err
+			}
+			e = variance(e, group)
+			p.pos++
+			continue
+		}
+		if p.pos == len(p.s) || !unicode.IsLetter(p.s[p.pos]) {
+			return e, p.errorf("expected an index")
+		}
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.s) && '0' <= p.s[p.pos] && p.s[p.pos] <= '9' {
+			p.pos++
+		}
+		e = variance(e, string(p.s[start:p.pos]))
+	}
+	return e, nil
+}
+
+// labels checks that every label in s, which starts at rune
+// start of the notation, is a letter followed by letters and digits.
+func (p *parser) labels(s string, start int) error {
+	at := 0
+	for _, l := range tokens(s) {
+		at += strings.Index(s[at:], l)
+		for k, r := range l {
+			if !unicode.IsLetter(r) && (k == 0 || !unicode.IsDigit(r)) {
+				column := start + utf8.RuneCountInString(s[:at]) + 1
+				return &SyntaxError{column, fmt.Sprintf("bad index %q", l)}
+			}
+		}
+		at += len(l)
+	}
+	return nil
+}
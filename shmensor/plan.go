@@ -0,0 +1,360 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"math/bits"
+	"strings"
+)
+
+// This file decides in which order Term.Eval contracts the
+// Expressions of a term. The order doesn't change the answer,
+// but it changes the work a lot. Multiplying a chain of matrices
+// pairwise is cheap, while taking the tensor product of everything
+// first is exponential in the length of the chain.
+
+// Strategy selects how Term.Eval orders pairwise contractions.
+type Strategy int
+
+const (
+	// Auto searches exhaustively on small terms and
+	// greedily on large ones.
+	Auto Strategy = iota
+	// Greedy repeatedly contracts the cheapest pair.
+	Greedy
+	// Exhaustive finds the cheapest order by dynamic programming
+	// over subsets. Terms longer than maxExhaustive, or with more
+	// than 64 index labels, fall back to Greedy.
+	Exhaustive
+	// RightToLeft folds the term from the right, the way
+	// Term.Eval always used to. Handy for comparisons.
+	RightToLeft
+)
+
+// Largest term Auto will search exhaustively,
+// and largest term Exhaustive will search at all.
+const (
+	autoExhaustive = 8
+	maxExhaustive  = 14
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case Auto:
+		return "auto"
+	case Greedy:
+		return "greedy"
+	case Exhaustive:
+		return "exhaustive"
+	case RightToLeft:
+		return "right-to-left"
+	}
+	return "unknown"
+}
+
+// A step contracts two operands of a plan. Operands are numbered
+// like registers: the inputs are 0..n-1 and step k produces n+k.
+type step struct {
+	a, b int
+}
+
+// A plan is an ordered list of pairwise contractions along with
+// the estimated cost of carrying it out.
+type plan struct {
+	strategy   Strategy
+	steps      []step
+	multiplies int
+	adds       int
+}
+
+// planner holds the index bookkeeping shared by all strategies.
+// Labels are small integers; dim maps a label to its dimension.
+type planner struct {
+	operands [][]int
+	dim      map[int]int
+	// total counts the operands each label appears in.
+	total map[int]int
+	// output labels survive the whole term.
+	output map[int]bool
+	// labels is one more than the largest label.
+	labels int
+	// order holds the output labels in the order of the result.
+	order []int
+}
+
+func newPlanner(operands [][]int, dim map[int]int, output []int) *planner {
+	p := &planner{
+		operands: operands,
+		dim:      dim,
+		total:    make(map[int]int),
+		output:   make(map[int]bool),
+		order:    output,
+	}
+	for _, labels := range operands {
+		for _, l := range labels {
+			p.total[l]++
+			if l >= p.labels {
+				p.labels = l + 1
+			}
+		}
+	}
+	for _, l := range output {
+		p.output[l] = true
+	}
+	return p
+}
+
+// free returns the labels of a group of operands that are still
+// needed once the group is contracted into one tensor: labels that
+// also live outside the group, and labels of the final output.
+func (p *planner) free(group []int) []int {
+	count := make(map[int]int)
+	var order []int
+	for _, o := range group {
+		for _, l := range p.operands[o] {
+			if count[l] == 0 {
+				order = append(order, l)
+			}
+			count[l]++
+		}
+	}
+	var ret []int
+	for _, l := range order {
+		if count[l] < p.total[l] || p.output[l] {
+			ret = append(ret, l)
+		}
+	}
+	return ret
+}
+
+// cost estimates the multiplies and adds of contracting two
+// operands with free labels a and b into one with free labels out.
+// Every output element is a sum over the contracted labels.
+func (p *planner) cost(a, b, out []int) (int, int) {
+	all := 1
+	seen := make(map[int]bool)
+	for _, l := range append(append([]int{}, a...), b...) {
+		if !seen[l] {
+			seen[l] = true
+			all *= p.dim[l]
+		}
+	}
+	size := p.size(out)
+	return all, all - size
+}
+
+func (p *planner) size(labels []int) int {
+	size := 1
+	for _, l := range labels {
+		size *= p.dim[l]
+	}
+	return size
+}
+
+// plan orders the contractions with the requested strategy.
+func (p *planner) plan(s Strategy) plan {
+	n := len(p.operands)
+	if s == Auto {
+		s = Greedy
+		if n <= autoExhaustive {
+			s = Exhaustive
+		}
+	}
+	if s == Exhaustive && (n > maxExhaustive || p.labels > 64) {
+		s = Greedy
+	}
+	switch s {
+	case Exhaustive:
+		return p.exhaustive()
+	case RightToLeft:
+		return p.rightToLeft()
+	}
+	return p.greedy()
+}
+
+// rightToLeft contracts the last two operands, then
+// contracts every earlier operand into the result.
+func (p *planner) rightToLeft() plan {
+	n := len(p.operands)
+	ret := plan{strategy: RightToLeft}
+	rhs := n - 1
+	group := []int{n - 1}
+	for i := n - 2; i >= 0; i-- {
+		a := p.free([]int{i})
+		b := p.free(group)
+		group = append(group, i)
+		muls, adds := p.cost(a, b, p.free(group))
+		ret.multiplies += muls
+		ret.adds += adds
+		ret.steps = append(ret.steps, step{i, rhs})
+		rhs = n + len(ret.steps) - 1
+	}
+	return ret
+}
+
+// greedy always performs the cheapest available contraction next.
+func (p *planner) greedy() plan {
+	n := len(p.operands)
+	ret := plan{strategy: Greedy}
+	// Live operands and the inputs they were built from.
+	var live []int
+	groups := make(map[int][]int)
+	for i := 0; i < n; i++ {
+		live = append(live, i)
+		groups[i] = []int{i}
+	}
+	for len(live) > 1 {
+		bi, bj := -1, -1
+		var bestMuls, bestAdds, bestSize int
+		for i := 0; i < len(live); i++ {
+			for j := i + 1; j < len(live); j++ {
+				ga, gb := groups[live[i]], groups[live[j]]
+				out := p.free(append(append([]int{}, ga...), gb...))
+				muls, adds := p.cost(p.free(ga), p.free(gb), out)
+				size := p.size(out)
+				if bi < 0 || muls < bestMuls || (muls == bestMuls && size < bestSize) {
+					bi, bj = i, j
+					bestMuls, bestAdds, bestSize = muls, adds, size
+				}
+			}
+		}
+		a, b := live[bi], live[bj]
+		id := n + len(ret.steps)
+		ret.steps = append(ret.steps, step{a, b})
+		ret.multiplies += bestMuls
+		ret.adds += bestAdds
+		groups[id] = append(append([]int{}, groups[a]...), groups[b]...)
+		live = append(live[:bj], live[bj+1:]...)
+		live[bi] = id
+	}
+	return ret
+}
+
+// exhaustive finds the cheapest order over all binary contraction
+// trees by dynamic programming over subsets of operands.
+func (p *planner) exhaustive() plan {
+	n := len(p.operands)
+	full := 1<<uint(n) - 1
+	type entry struct {
+		muls, adds int
+		split      int
+		free       []int
+		// bits holds the free labels, size their volume.
+		bits uint64
+		size int
+	}
+	table := make([]entry, full+1)
+	dims := make([]int, p.labels)
+	for l := range dims {
+		dims[l] = p.dim[l]
+	}
+	// volume multiplies the dimensions of a set of labels,
+	// without allocating, since it runs for every split.
+	volume := func(b uint64) int {
+		v := 1
+		for ; b != 0; b &= b - 1 {
+			v *= dims[bits.TrailingZeros64(b)]
+		}
+		return v
+	}
+	members := func(mask int) []int {
+		var ret []int
+		for i := 0; i < n; i++ {
+			if mask&(1<<uint(i)) != 0 {
+				ret = append(ret, i)
+			}
+		}
+		return ret
+	}
+	for mask := 1; mask <= full; mask++ {
+		table[mask].free = p.free(members(mask))
+		for _, l := range table[mask].free {
+			table[mask].bits |= 1 << uint(l)
+		}
+		table[mask].size = p.size(table[mask].free)
+		if mask&(mask-1) == 0 {
+			continue
+		}
+		low := mask & -mask
+		best := -1
+		// Only visit splits holding the lowest operand,
+		// so every pair of halves is seen once.
+		for s := (mask - 1) & mask; s > 0; s = (s - 1) & mask {
+			if s&low == 0 {
+				continue
+			}
+			l, r := &table[s], &table[mask^s]
+			// The same estimate as cost.
+			all := volume(l.bits | r.bits)
+			muls := all + l.muls + r.muls
+			adds := all - table[mask].size + l.adds + r.adds
+			if best < 0 || muls < table[mask].muls {
+				best = s
+				table[mask].muls, table[mask].adds = muls, adds
+			}
+		}
+		table[mask].split = best
+	}
+
+	ret := plan{
+		strategy:   Exhaustive,
+		multiplies: table[full].muls,
+		adds:       table[full].adds,
+	}
+	// Unroll the tree into steps, children before parents.
+	var build func(mask int) int
+	build = func(mask int) int {
+		if mask&(mask-1) == 0 {
+			for i := 0; i < n; i++ {
+				if mask == 1<<uint(i) {
+					return i
+				}
+			}
+		}
+		a := build(table[mask].split)
+		b := build(mask ^ table[mask].split)
+		ret.steps = append(ret.steps, step{a, b})
+		return n + len(ret.steps) - 1
+	}
+	build(full)
+	return ret
+}
+
+// describe renders a plan like "ab*bc->ac cd*ac->da",
+// naming every label with the given function. The last step
+// gives the labels in the order of the result.
+func (p *planner) describe(pl plan, name func(int) string) string {
+	groups := make(map[int][]int)
+	for i := range p.operands {
+		groups[i] = []int{i}
+	}
+	render := func(labels []int) string {
+		s := ""
+		for _, l := range labels {
+			s += name(l)
+		}
+		return s
+	}
+	var parts []string
+	for k, s := range pl.steps {
+		ga, gb := groups[s.a], groups[s.b]
+		g := append(append([]int{}, ga...), gb...)
+		groups[len(p.operands)+k] = g
+		out := p.free(g)
+		if k == len(pl.steps)-1 {
+			out = p.order
+		}
+		parts = append(parts, render(p.free(ga))+"*"+render(p.free(gb))+"->"+render(out))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,255 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Most of the work shmensor does happens lazily, long after Eval
+// returns, inside closures that may run on several goroutines at
+// once. The Profiler hands every closure a node of its own to count
+// into with atomic operations, and only adds the nodes up when
+// somebody asks for Stats.
+
+// Op is a kind of operation the Profiler keeps time for.
+type Op string
+
+const (
+	// Planning the contraction order of a term.
+	OpPlan Op = "plan"
+	// Computing elements of products, traces and contractions.
+	OpContract Op = "contract"
+	// Materializing intermediates of a term.
+	OpMaterialize Op = "materialize"
+	// Computing elements of sums, scalings and function applications.
+	OpPlus  Op = "plus"
+	OpScale Op = "scale"
+	OpApply Op = "apply"
+)
+
+// Profiler collects metrics about Tensor evaluation for
+// debugging and benchmarking. It is safe for concurrent use.
+type Profiler struct {
+	mu sync.Mutex
+	// Nodes in the order they were built.
+	nodes []*node
+	// Profilers of nested Evaluators.
+	children []*Profiler
+	// Time spent outside of any node, like planning.
+	wall map[Op]time.Duration
+	// What Term.Eval planned. See plan.go and checkpoint.go.
+	path                string
+	estimatedMultiplies int
+	estimatedAdds       int
+	checkpoints         []Checkpoint
+}
+
+// A node counts the work of one closure built during evaluation.
+type node struct {
+	name string
+	op   Op
+	size int
+
+	multiplies atomic.Int64
+	adds       atomic.Int64
+	calls      atomic.Int64
+	hits       atomic.Int64
+	misses     atomic.Int64
+	evictions  atomic.Int64
+	wall       atomic.Int64
+}
+
+// Stats is a snapshot of a Profiler. Totals cover the
+// Profiler and the Profilers of every nested Evaluator.
+type Stats struct {
+	Multiplies int
+	Adds       int
+	// Calls counts invocations of closures built by shmensor.
+	Calls       int
+	CacheHits   int
+	CacheMisses int
+	// CacheEvictions counts elements dropped from full caches.
+	CacheEvictions int
+	// PeakSize is the number of elements of the largest
+	// tensor built along the way.
+	PeakSize int
+	// Wall is the time spent per kind of operation. Operations
+	// run inside each other, so the times of nested ones overlap.
+	Wall map[Op]time.Duration
+	// The contraction order of the term, and its estimated cost.
+	Path                string
+	EstimatedMultiplies int
+	EstimatedAdds       int
+	Checkpoints         []Checkpoint
+	// Nodes breaks the totals down by the closure that did the work,
+	// nested Evaluators first.
+	Nodes []NodeStats
+}
+
+// NodeStats is the work done by one closure.
+type NodeStats struct {
+	// Node names the Expressions that produced the closure,
+	// like "^a_b*^b_c->^a_c".
+	Node           string
+	Op             Op
+	Size           int
+	Multiplies     int
+	Adds           int
+	Calls          int
+	CacheHits      int
+	CacheMisses    int
+	CacheEvictions int
+	Wall           time.Duration
+}
+
+// node registers a closure of the given size with the Profiler.
+// A nil Profiler hands out nodes that count into nothing.
+func (p *Profiler) node(name string, op Op, size int) *node {
+	n := &node{name: name, op: op, size: size}
+	if p == nil {
+		return n
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nodes = append(p.nodes, n)
+	return n
+}
+
+// adopt makes the Profilers of nested Evaluators part of this one.
+func (p *Profiler) adopt(children ...*Profiler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range children {
+		if c != nil && c != p {
+			p.children = append(p.children, c)
+		}
+	}
+}
+
+// time adds to the time spent on an operation outside of any node.
+func (p *Profiler) time(op Op, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.wall == nil {
+		p.wall = make(map[Op]time.Duration)
+	}
+	p.wall[op] += d
+}
+
+// plan records what Term.Eval planned.
+func (p *Profiler) plan(path string, multiplies, adds int, checkpoints []Checkpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.path = path
+	p.estimatedMultiplies = multiplies
+	p.estimatedAdds = adds
+	p.checkpoints = checkpoints
+}
+
+// elapsed adds the time since start to the node.
+func (n *node) elapsed(start time.Time) {
+	n.wall.Add(int64(time.Since(start)))
+}
+
+// Stats adds up everything the Profiler has seen so far.
+func (p *Profiler) Stats() Stats {
+	s := Stats{Wall: make(map[Op]time.Duration)}
+	p.mu.Lock()
+	children := p.children
+	nodes := p.nodes
+	for op, d := range p.wall {
+		s.Wall[op] += d
+	}
+	s.Path = p.path
+	s.EstimatedMultiplies = p.estimatedMultiplies
+	s.EstimatedAdds = p.estimatedAdds
+	s.Checkpoints = p.checkpoints
+	p.mu.Unlock()
+
+	for _, c := range children {
+		cs := c.Stats()
+		s.Multiplies += cs.Multiplies
+		s.Adds += cs.Adds
+		s.Calls += cs.Calls
+		s.CacheHits += cs.CacheHits
+		s.CacheMisses += cs.CacheMisses
+		s.CacheEvictions += cs.CacheEvictions
+		if cs.PeakSize > s.PeakSize {
+			s.PeakSize = cs.PeakSize
+		}
+		for op, d := range cs.Wall {
+			s.Wall[op] += d
+		}
+		s.Nodes = append(s.Nodes, cs.Nodes...)
+	}
+	for _, n := range nodes {
+		ns := NodeStats{
+			Node:           n.name,
+			Op:             n.op,
+			Size:           n.size,
+			Multiplies:     int(n.multiplies.Load()),
+			Adds:           int(n.adds.Load()),
+			Calls:          int(n.calls.Load()),
+			CacheHits:      int(n.hits.Load()),
+			CacheMisses:    int(n.misses.Load()),
+			CacheEvictions: int(n.evictions.Load()),
+			Wall:           time.Duration(n.wall.Load()),
+		}
+		s.Multiplies += ns.Multiplies
+		s.Adds += ns.Adds
+		s.Calls += ns.Calls
+		s.CacheHits += ns.CacheHits
+		s.CacheMisses += ns.CacheMisses
+		s.CacheEvictions += ns.CacheEvictions
+		if ns.Size > s.PeakSize {
+			s.PeakSize = ns.Size
+		}
+		s.Wall[n.op] += ns.Wall
+		s.Nodes = append(s.Nodes, ns)
+	}
+	return s
+}
+
+// Pretty printing.
+func (p *Profiler) String() string {
+	s := p.Stats()
+	ret := fmt.Sprintf("Muls: %v\t Adds: %v\t Calls: %v\t Cache Hits: %v\t Misses: %v\t Evictions: %v\t Peak Size: %v",
+		s.Multiplies, s.Adds, s.Calls, s.CacheHits, s.CacheMisses, s.CacheEvictions, s.PeakSize)
+	if s.Path != "" {
+		ret += fmt.Sprintf("\nPlan: %v\nEstimated Muls: %v\t Estimated Adds: %v",
+			s.Path, s.EstimatedMultiplies, s.EstimatedAdds)
+	}
+	for _, c := range s.Checkpoints {
+		if c.Materialized {
+			ret += fmt.Sprintf("\nMaterialized %v", c)
+		}
+	}
+	var ops []string
+	for op := range s.Wall {
+		ops = append(ops, string(op))
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		ret += fmt.Sprintf("\nTime in %v: %v", op, s.Wall[Op(op)])
+	}
+	for _, n := range s.Nodes {
+		ret += fmt.Sprintf("\n  %v %v\t size %v\t muls %v\t adds %v\t calls %v\t hits %v\t misses %v\t evictions %v\t %v",
+			n.Op, n.Node, n.Size, n.Multiplies, n.Adds, n.Calls, n.CacheHits, n.CacheMisses, n.CacheEvictions, n.Wall)
+	}
+	return ret
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Rationals are exact fractions of any size, for determinants,
+// inverses and polynomials that ints can't divide.
+//
+// A *big.Rat can be modified in place, and a lazy Tensor hands the
+// same element to many readers: a cached sum is read by every
+// product that needs it. So RationalRing never modifies the
+// elements it is given and allocates every result, the constructors
+// copy what they are handed, and a Function gets a copy of each
+// element to do with as it likes. Elements read out of a Tensor,
+// as with Reify, are shared in the same way; copy one with
+// new(big.Rat).Set before modifying it.
+
+// RationalRing is the Ring of *big.Rat.
+type RationalRing struct{}
+
+func (RationalRing) Multiply(x, y *big.Rat) *big.Rat {
+	return new(big.Rat).Mul(x, y)
+}
+
+func (RationalRing) Add(x, y *big.Rat) *big.Rat {
+	return new(big.Rat).Add(x, y)
+}
+
+func (RationalRing) Negate(x *big.Rat) *big.Rat {
+	return new(big.Rat).Neg(x)
+}
+
+func (RationalRing) Zero() *big.Rat {
+	return new(big.Rat)
+}
+
+func (RationalRing) One() *big.Rat {
+	return big.NewRat(1, 1)
+}
+
+func (RationalRing) Equal(x, y *big.Rat) bool {
+	return x.Cmp(y) == 0
+}
+
+// Print writes x like "3" or "-1/2", for Tensor.String.
+func (RationalRing) Print(x *big.Rat) string {
+	return x.RatString()
+}
+
+// Parse reads a rational the way Print writes it, or as a
+// decimal like "0.25" or "1e-3".
+func (RationalRing) Parse(s string) (*big.Rat, error) {
+	x, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("rational: can't parse %q", s)
+	}
+	return x, nil
+}
+
+// RationalType is RationalRing as a Type, the Type of rational Tensors.
+var RationalType Type = boxed[*big.Rat]{RationalRing{}}
+
+// NewRationalTensor makes a lazy rational Tensor. What f returns
+// is copied, so f may go on to modify it.
+func NewRationalTensor(f func(i ...int) *big.Rat, signature string, dim []int) Tensor {
+	return Box(NewTensorOf(RationalRing{}, copyRationals(f), signature, dim))
+}
+
+// copyRationals copies what f returns.
+func copyRationals(f func(i ...int) *big.Rat) func(i ...int) *big.Rat {
+	return func(i ...int) *big.Rat {
+		return new(big.Rat).Set(f(i...))
+	}
+}
+
+// NewDenseRationalTensor lays a rational tensor over a copy of a
+// flat slice, like NewDenseTensorOf.
+func NewDenseRationalTensor(data []*big.Rat, strides []int, signature string, dim []int) Tensor {
+	return newDense(box(copyRationalSlice(data)), strides, signature, dim, RationalType)
+}
+
+func copyRationalSlice(data []*big.Rat) []*big.Rat {
+	ret := make([]*big.Rat, len(data))
+	for i, x := range data {
+		ret[i] = new(big.Rat).Set(x)
+	}
+	return ret
+}
+
+// NewRationalFunction makes a Function on rationals. f is
+// handed a copy of each element, so it may modify it.
+func NewRationalFunction(f func(r *big.Rat) *big.Rat) Function {
+	wrapper := func(i interface{}) interface{} {
+		return f(new(big.Rat).Set(i.(*big.Rat)))
+	}
+	return Function{
+		wrapper,
+		RationalType,
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"math/rand"
+	"time"
+)
+
+// A Ring knows its identities, so shmensor can build tensors out of
+// them for any element type:
+//
+//	Zeros(IntType, "ud", []int{2, 3})        every element 0
+//	Identity(RealRing{}, "ud", []int{3, 3})  the Kronecker delta
+//
+// and a sum with no terms, like contracting an index of
+// dimension zero, is the Zero of the Ring.
+
+// Zeros returns the TensorOf elements of r that are all r.Zero().
+func Zeros[T any](r Ring[T], signature string, dim []int) TensorOf[T] {
+	zero := r.Zero()
+	return NewTensorOf(r, func(i ...int) T { return zero }, signature, dim)
+}
+
+// Identity returns the Kronecker delta of elements of r: One
+// where every index has the same value, and Zero elsewhere. With
+// signature "ud" it is the identity matrix; with more indices it
+// ties them all together.
+func Identity[T any](r Ring[T], signature string, dim []int) TensorOf[T] {
+	zero, one := r.Zero(), r.One()
+	return NewTensorOf(r, func(i ...int) T {
+		for _, x := range i {
+			if x != i[0] {
+				return zero
+			}
+		}
+		return one
+	}, signature, dim)
+}
+
+// Equal reports whether t and u have the same signature and
+// dimensions, and elements equal in the Ring of t. It reads
+// every element of both.
+func (t TensorOf[T]) Equal(u TensorOf[T]) bool {
+	if t.t == nil || u.t == nil {
+		return t.t == nil && u.t == nil
+	}
+	if t.signature != u.signature || len(t.dim) != len(u.dim) {
+		return false
+	}
+	for k := range t.dim {
+		if t.dim[k] != u.dim[k] {
+			return false
+		}
+	}
+	for n := 0; n < size(t.dim); n++ {
+		i := giveCoordinate(t.dim, n)
+		if !t.t.Equal(t.f(i...), u.f(i...)) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckRing tests the ring laws on n triples of elements drawn
+// with sample, and on the identities of r, like so
+//
+//	a + (b + c) = (a + b) + c    a(bc) = (ab)c
+//	a + b = b + a                a(b + c) = ab + ac
+//	a + 0 = a                    (a + b)c = ac + bc
+//	a + -a = 0                   1a = a1 = a
+//
+// comparing with r.Equal. It returns an ErrRingLaw for the first
+// law that fails. The draws are the same on every call, so a
+// failure is reproducible.
+//
+// Mod 7 example, in a test
+// err := CheckRing(mod7{}, func(r *rand.Rand) interface{} { return r.Intn(7) }, 100)
+//
+// Elements of a RealRing should be drawn from small integers, or
+// rounding will break associativity.
+func CheckRing[T any](r Ring[T], sample func(*rand.Rand) T, n int) error {
+	rnd := rand.New(rand.NewSource(1))
+	zero, one := r.Zero(), r.One()
+	for k := 0; k < n; k++ {
+		a, b, c := sample(rnd), sample(rnd), sample(rnd)
+		laws := []struct {
+			law         string
+			left, right T
+		}{
+			{"associativity of Add", r.Add(a, r.Add(b, c)), r.Add(r.Add(a, b), c)},
+			{"commutativity of Add", r.Add(a, b), r.Add(b, a)},
+			{"additive identity", r.Add(a, zero), a},
+			{"additive inverse", r.Add(a, r.Negate(a)), zero},
+			{"associativity of Multiply", r.Multiply(a, r.Multiply(b, c)), r.Multiply(r.Multiply(a, b), c)},
+			{"left distributivity", r.Multiply(a, r.Add(b, c)), r.Add(r.Multiply(a, b), r.Multiply(a, c))},
+			{"right distributivity", r.Multiply(r.Add(a, b), c), r.Add(r.Multiply(a, c), r.Multiply(b, c))},
+			{"multiplicative identity", r.Multiply(one, a), a},
+			{"multiplicative identity", r.Multiply(a, one), a},
+		}
+		for _, l := range laws {
+			if !r.Equal(l.left, l.right) {
+				return ErrRingLaw{Law: l.law, Elements: []interface{}{a, b, c}}
+			}
+		}
+	}
+	return nil
+}
+
+// A CheckedRing is a Ring whose operations can fail, like Int64Ring
+// does on overflow. An operation fails by calling Fail. Since an
+// element is only computed when it is read, Eval reads every element
+// of a Tensor over a CheckedRing before returning it, so that the
+// failure comes out of Eval as an error. Outside of Eval, as in
+// Trace or Reify, a failure is a panic.
+type CheckedRing interface {
+	// Checked does nothing; it marks the Ring.
+	Checked()
+}
+
+// Fail stops the Eval that an operation of a CheckedRing was
+// called from, and makes it return err.
+func Fail(err error) {
+	panic(failure{err})
+}
+
+// failure is what Fail panics with, so that checked can tell a
+// failed operation from a bug.
+type failure struct {
+	err error
+}
+
+// checks reports whether r, or the Ring boxed in it, is a CheckedRing.
+func checks(r interface{}) bool {
+	if b, ok := r.(interface{ inner() interface{} }); ok {
+		r = b.inner()
+	}
+	_, ok := r.(CheckedRing)
+	return ok
+}
+
+// checked runs eval, and materializes what it gives if the Ring
+// is a CheckedRing. A Fail on the way is returned as an error.
+func checked[T any](eval func() (TensorOf[T], error, *Profiler)) (t TensorOf[T], err error, p *Profiler) {
+	defer func() {
+		if r := recover(); r != nil {
+			f, ok := r.(failure)
+			if !ok {
+				panic(r)
+			}
+			t, err = TensorOf[T]{}, f.err
+			if p == nil {
+				p = &Profiler{}
+			}
+		}
+	}()
+	t, err, p = eval()
+	if err == nil && checks(t.t) {
+		start := time.Now()
+		t = t.Materialize()
+		if p != nil {
+			p.time(OpMaterialize, time.Since(start))
+		}
+	}
+	return t, err, p
+}
@@ -0,0 +1,252 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Shapes let callers ask what an Evaluator will give without
+// building or computing anything, like
+//
+//	s, err := E(a.U("i").D("j"), b.U("j").D("k")).Shape()
+//
+// and they catch the same mistakes Eval does, mismatched dimensions
+// and all. Term.Eval checks the Shape of the whole tree under it
+// before it builds a single closure.
+
+// Shape is what an Evaluator evaluates to, short of its elements.
+// A Tensor is its own Shape.
+type Shape interface {
+	Signature() string
+	Dimension() []int
+	// FreeIndices labels the slots, or is nil if they have no labels.
+	FreeIndices() []string
+}
+
+// A ShaperOf is an Evaluator that knows its Shape without evaluating.
+// Every Evaluator in this package is one.
+type ShaperOf[T any] interface {
+	EvaluatorOf[T]
+	Shape() (Shape, error)
+}
+
+type Shaper = ShaperOf[interface{}]
+
+// ShapeOf returns the Shape of e, evaluating it only if e
+// isn't a Shaper.
+func ShapeOf[T any](e EvaluatorOf[T]) (Shape, error) {
+	if s, ok := e.(ShaperOf[T]); ok {
+		return s.Shape()
+	}
+	t, err, _ := e.Eval()
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+type shape struct {
+	signature string
+	dim       []int
+	free      []string
+	// The Ring of the elements, if known.
+	t interface{}
+}
+
+func (s shape) Signature() string {
+	return s.signature
+}
+
+func (s shape) Dimension() []int {
+	return s.dim
+}
+
+func (s shape) FreeIndices() []string {
+	return s.free
+}
+
+func (s shape) ring() interface{} {
+	return s.t
+}
+
+// typeOf is the Ring of the elements of what has Shape s, if known.
+func typeOf(s Shape) interface{} {
+	if r, ok := s.(interface{ ring() interface{} }); ok {
+		return r.ring()
+	}
+	return nil
+}
+
+// FreeIndices is nil: the slots of a Tensor have no labels.
+func (t TensorOf[T]) FreeIndices() []string {
+	return nil
+}
+
+func (t TensorOf[T]) ring() interface{} {
+	return t.t
+}
+
+func (t TensorOf[T]) Shape() (Shape, error) {
+	return t, nil
+}
+
+func (e ExpressionOf[T]) Shape() (Shape, error) {
+	if e.t == nil {
+		return ShapeOf(e.e)
+	}
+	return *e.t, nil
+}
+
+func (term TermOf[T]) Shape() (Shape, error) {
+	if len(term.List) == 0 {
+		return shape{}, nil
+	}
+	// Stand in Tensors with no elements for the real ones.
+	list := make([]ExpressionOf[T], len(term.List))
+	for k, e := range term.List {
+		s, err := e.Shape()
+		if err != nil {
+			return nil, fmt.Errorf("term: expression %v: %w", k+1, err)
+		}
+		r, _ := typeOf(s).(Ring[T])
+		list[k] = e.settle(TensorOf[T]{signature: s.Signature(), dim: s.Dimension(), t: r})
+	}
+	l, err := term.layout(list)
+	if err != nil {
+		return nil, err
+	}
+	return l.shape, nil
+}
+
+func (ps PlusOf[T]) Shape() (Shape, error) {
+	return sumShape("plus", []EvaluatorOf[T]{ps.A, ps.B})
+}
+
+func (s SumOf[T]) Shape() (Shape, error) {
+	return sumShape("sum", s)
+}
+
+func (as ApplyOf[T]) Shape() (Shape, error) {
+	s, err := ShapeOf(as.E)
+	if err != nil {
+		return nil, fmt.Errorf("apply: %w", err)
+	}
+	if t := typeOf(s); t != nil && !reflect.DeepEqual(t, as.Func.t) {
+		return nil, ErrTypeMismatch{Op: "apply", A: as.Func.t, B: t}
+	}
+	return s, nil
+}
+
+func (s ScaledOf[T]) Shape() (Shape, error) {
+	sh, err := scaleShape("scale", s.E)
+	if err != nil {
+		return nil, err
+	}
+	if t := typeOf(sh); t != nil && !holds(t, s.C) {
+		return nil, ErrTypeMismatch{Op: "scale", A: s.C, B: t}
+	}
+	return sh, nil
+}
+
+func (n NegatedOf[T]) Shape() (Shape, error) {
+	return scaleShape("negate", n.E)
+}
+
+func scaleShape[T any](op string, e EvaluatorOf[T]) (Shape, error) {
+	s, err := ShapeOf(e)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", op, err)
+	}
+	return s, nil
+}
+
+// sumShape checks that the terms of a sum fit together, lined up
+// by label, and returns the Shape of the sum.
+func sumShape[T any](op string, es []EvaluatorOf[T]) (Shape, error) {
+	if len(es) == 0 {
+		return nil, ErrEmptySum{Op: op}
+	}
+	shapes := make([]shape, len(es))
+	var want []string
+	base := -1
+	for k, e := range es {
+		e = asTerm(e)
+		s, err := ShapeOf(e)
+		if err != nil {
+			return nil, fmt.Errorf("%v: term %v: %w", op, k+1, err)
+		}
+		shapes[k] = shape{signature: s.Signature(), dim: s.Dimension(), t: typeOf(s)}
+		if l, ok := labelsOf(e); ok {
+			shapes[k].free = l
+			if base < 0 {
+				base, want = k, l
+			}
+		}
+	}
+	for k, s := range shapes {
+		if k == base || s.free == nil || base < 0 {
+			continue
+		}
+		aligned, err := align(op, s, shapes[base])
+		if err != nil {
+			return nil, err
+		}
+		shapes[k] = aligned
+	}
+
+	first := shapes[0]
+	for _, s := range shapes[1:] {
+		if !reflect.DeepEqual(first.dim, s.dim) {
+			return nil, ErrDimensionMismatch{Op: op, A: first.dim, B: s.dim}
+		}
+		if first.signature != s.signature {
+			return nil, ErrSignatureMismatch{Op: op, Signature: s.signature, Want: first.signature}
+		}
+		if first.t != nil && s.t != nil && !reflect.DeepEqual(first.t, s.t) {
+			return nil, ErrTypeMismatch{Op: op, A: first.t, B: s.t}
+		}
+	}
+	first.free = want
+	return first, nil
+}
+
+// align permutes s so that its labels come in the order of those
+// of base, checking that each has the same variance and dimension.
+func align(op string, s, base shape) (shape, error) {
+	mismatch := ErrIndexMismatch{Op: op, A: render(base.free), B: render(s.free)}
+	if len(s.free) != len(base.free) {
+		return shape{}, mismatch
+	}
+	for _, ch := range base.free {
+		if indexOf(s.free, ch) < 0 {
+			return shape{}, mismatch
+		}
+	}
+	ret := shape{dim: make([]int, len(s.dim)), free: base.free, t: s.t}
+	for j, ch := range base.free {
+		x := indexOf(s.free, ch)
+		if s.signature[x] != base.signature[j] {
+			return shape{}, ErrSignatureMismatch{Op: op, Index: ch,
+				Signature: s.signature[x : x+1], Want: base.signature[j : j+1]}
+		}
+		if s.dim[x] != base.dim[j] {
+			return shape{}, ErrDimensionMismatch{Op: op, Index: ch,
+				A: []int{base.dim[j]}, B: []int{s.dim[x]}}
+		}
+		ret.signature += s.signature[x : x+1]
+		ret.dim[j] = s.dim[x]
+	}
+	return ret, nil
+}
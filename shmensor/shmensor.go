@@ -14,35 +14,54 @@ package shmensor
 
 import (
 	"fmt"
-	"index/suffixarray"
-	"log"
 	"reflect"
-	"sort"
+	"strings"
+	"time"
 )
 
-// Type defines a ring element. To implement the interface
-// define multiplication and addition of the ring elements.
+// Ring defines a ring over elements of type T. To implement the
+// interface define multiplication and addition of the ring elements.
 //
-// Several default Tensor types and initializer functions
-// are defined at the end of the file.
+// Everything in shmensor is generic in its elements: a
+// TensorOf[float64] holds float64s, its coordinate function returns
+// them unboxed, and a TermOf[float64] only takes Expressions of
+// TensorOf[float64], so mixing element types fails to compile.
+// Tensor, Term and the rest are the same things over interface{},
+// the API shmensor has always had. See the typed package for
+// constructors of the generic ones.
 //
 // Note that most applications expect the inputs and output
 // to be of the same type. You have to enforce that at
 // object construction.
-type Type interface {
+type Ring[T any] interface {
 	// Take two things, like numbers, get a new thing.
-	Multiply(interface{}, interface{}) interface{}
+	Multiply(T, T) T
 	// Take two things, like numbers, get a new thing.
-	Add(interface{}, interface{}) interface{}
+	Add(T, T) T
+	// Take a thing, get the thing that adds to it to make zero.
+	Negate(T) T
+	// The thing that adds to anything and leaves it as it was.
+	Zero() T
+	// The thing that multiplies anything and leaves it as it was.
+	One() T
+	// Take two things, say if they are the same element.
+	Equal(T, T) bool
 }
 
-// A Tensor is not a matrix. It's a tensor.
-// A Tensor is a multi-dimensional array that
+// Type is a Ring over interface{}, for Tensors whose elements are
+// checked as they are computed rather than at compile time.
+//
+// Several default Tensor types and initializer functions
+// are defined in types.go.
+type Type = Ring[interface{}]
+
+// A TensorOf is not a matrix. It's a tensor.
+// A TensorOf is a multi-dimensional array that
 // transforms according to the co and contravariant.
 // tensor transformation laws.
-type Tensor struct {
+type TensorOf[T any] struct {
 	//coordinate function
-	f func(i ...int) interface{}
+	f func(i ...int) T
 	// signature like "uddduu"
 	// combo of contra and co indices
 	signature string
@@ -51,69 +70,117 @@ type Tensor struct {
 	dim []int
 	// Type of Tensor elt. Like real number, complex number
 	// rational number.
-	t Type
+	t Ring[T]
+	// Backing storage of a dense Tensor; nil when lazy.
+	// See dense.go.
+	s *storage[T]
+	// Goroutines Reify and Materialize may use. See parallel.go.
+	workers int
 }
-type Evaluator interface {
-	Eval() (Tensor, error, *Profiler)
+
+// A Tensor is a TensorOf elements of any Type.
+type Tensor = TensorOf[interface{}]
+
+type EvaluatorOf[T any] interface {
+	Eval() (TensorOf[T], error, *Profiler)
 }
 
-// An Expression is not a Tensor. It's an Expression symbolizing a
+type Evaluator = EvaluatorOf[interface{}]
+
+// An ExpressionOf is not a Tensor. It's an Expression symbolizing a
 // desired combination of tensor products and contractions on Tensors.
 // When you evaluate it, you get a Tensor.
 // Abstract index notation with Einstein summation.
-type Expression struct {
-	t         *Tensor
-	indices   string
+type ExpressionOf[T any] struct {
+	t *TensorOf[T]
+	// An Evaluator to give t, if not yet evaluated. See nest.go.
+	e EvaluatorOf[T]
+	// Slot orders declared with I before there was a t.
+	pending [][]int
+	// Index labels. See labels.go.
+	indices   []string
 	signature string
+	// The slot of the Tensor each index goes to,
+	// if not in order. See I.
+	slots []int
 }
 
-// A Term is a list of Expressions. It represents a single term
+type Expression = ExpressionOf[interface{}]
+
+// A TermOf is a list of Expressions. It represents a single term
 // of tensor products and contractions in abstract index notation.
-type Term struct {
-	List []Expression
+type TermOf[T any] struct {
+	List []ExpressionOf[T]
+	// How to order the contractions. See plan.go.
+	strategy Strategy
+	// Which intermediates to materialize. See checkpoint.go.
+	policy Policy
+	// Goroutines to materialize with. See parallel.go.
+	workers int
+	// Elements each contraction caches. Zero means
+	// DefaultCacheCapacity, less than zero none. See cache.go.
+	cache int
+	// The free indices of the result in order, if declared with To.
+	output  []string
+	ordered bool
+	// The metric to sum same variance indices with. See metric.go.
+	metric *MetricOf[T]
+	// Whether to skip the variance checks. See Permissive.
+	permissive bool
+	// Whether indices may appear more than twice. See Generalized.
+	generalized bool
 }
 
-// Plus contains the sum of two Terms.
-type Plus struct {
-	A, B Evaluator
+type Term = TermOf[interface{}]
+
+// PlusOf contains the sum of two Terms, lined up by index
+// label. See sum.go.
+type PlusOf[T any] struct {
+	A, B EvaluatorOf[T]
 }
 
-// Apply contains a function and an
+type Plus = PlusOf[interface{}]
+
+// ApplyOf contains a function and an
 // Evaluator interface to apply it to.
-type Apply struct {
-	Func Function
-	E    Evaluator
+type ApplyOf[T any] struct {
+	Func FunctionOf[T]
+	E    EvaluatorOf[T]
 }
 
-// Function is a function that can be applied to every element of a Tensor.
-// As of now, a caller can't make their own. They have to use a
-// constructor from the types file which ensures types are aligned.
-type Function struct {
-	f func(interface{}) interface{}
-	t Type
-}
+type Apply = ApplyOf[interface{}]
 
-// Profiler collects metrics about Tensor evaluation for
-// debugging and be
-type Profiler struct {
-	Multiplies int
-	Adds       int
-	Mutex      bool
-	TraceCache int
+// FunctionOf is a function that can be applied to every element of a Tensor.
+// Make one with NewFunction or NewFunctionOf, or a constructor
+// from the types file, which ensures types are aligned.
+type FunctionOf[T any] struct {
+	f func(T) T
+	t Ring[T]
 }
 
-// Pretty printing.
-func (p *Profiler) String() string {
-	s := fmt.Sprintf("Muls: %v\t Adds: %v\t Cached Traces Hits: %v", p.Multiplies, p.Adds, p.TraceCache)
-	return s
+type Function = FunctionOf[interface{}]
+
+// A Printer is a Ring that writes out its own elements
+// for Tensor.String, rather than leaving it to fmt.
+type Printer[T any] interface {
+	Print(T) string
 }
 
 // Pretty printing.
-func (t Tensor) String() string {
+func (t TensorOf[T]) String() string {
 	ret := "\n"
 	grid := t.Reify()
+	p, ok := t.t.(Printer[T])
 	for _, row := range grid {
-		ret += fmt.Sprintf("%v\n", row)
+		if !ok {
+			ret += fmt.Sprintf("%v\n", row)
+			continue
+		}
+		cells := make([]string, len(row))
+		for k, x := range row {
+			cells[k] = p.Print(x)
+		}
+		ret += "[" + strings.Join(cells, " ") + "]\n"
 	}
 	//fmt.Printf("%v", t.Reify())
 	ret += fmt.Sprintf("Signature: \"%v\"\n\n", t.signature)
@@ -121,15 +188,15 @@ func (t Tensor) String() string {
 }
 
 // Some getters.
-func (t Tensor) Signature() string {
+func (t TensorOf[T]) Signature() string {
 	return t.signature
 }
 
-func (t Tensor) Dimension() []int {
+func (t TensorOf[T]) Dimension() []int {
 	return t.dim
 }
 
-func (t Tensor) ContravariantIndices() []int {
+func (t TensorOf[T]) ContravariantIndices() []int {
 	var ret []int
 	for i, ch := range t.signature {
 		if string(ch) == "u" {
@@ -139,7 +206,7 @@ func (t Tensor) ContravariantIndices() []int {
 	return ret
 }
 
-func (t Tensor) CovariantIndices() []int {
+func (t TensorOf[T]) CovariantIndices() []int {
 	var ret []int
 	for i, ch := range t.signature {
 		if string(ch) == "d" {
@@ -149,12 +216,16 @@ func (t Tensor) CovariantIndices() []int {
 	return ret
 }
 
-func (t *Tensor) Reshape(signature string) {
-	if len(signature) != len(t.signature) {
-		fmt.Printf("Woops Tensor has sig %v\n", t.signature)
-		panic("Trying to perform invalid reshape.")
+// Reshape changes the variance of the Tensor's indices. The new
+// signature must have one 'u' or 'd' per index; otherwise the
+// Tensor is left as it was and an ErrSignatureMismatch returned.
+func (t *TensorOf[T]) Reshape(signature string) error {
+	if len(signature) != len(t.signature) ||
+		strings.Trim(signature, "ud") != "" {
+		return ErrSignatureMismatch{Op: "reshape", Signature: signature, Want: t.signature}
 	}
 	t.signature = signature
+	return nil
 }
 
 // Like t.U("ij").D("k").U("a").D("b")
@@ -165,33 +236,208 @@ func (t *Tensor) Reshape(signature string) {
 // Eval(t1.U("i"), t2.D("j"))
 // Transpose like
 // Eval(t1.I().U("j").D("i"))
-func (t *Tensor) U(indices string) Expression {
-	return Expression{t, "", ""}.U(indices)
+func (t *TensorOf[T]) U(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{t: t}.U(indices)
 }
 
-func (t *Tensor) D(indices string) Expression {
-	return Expression{t, "", ""}.D(indices)
+func (t *TensorOf[T]) D(indices string) ExpressionOf[T] {
+	return ExpressionOf[T]{t: t}.D(indices)
 }
 
-func (e Expression) U(indices string) Expression {
-	for range indices {
-		e.signature += "u"
+// I is Expression.I on an Expression of t.
+func (t *TensorOf[T]) I(slots ...int) ExpressionOf[T] {
+	return ExpressionOf[T]{t: t}.I(slots...)
+}
+
+// I declares the order in which the indices of the Expression go
+// to the slots of its Tensor: the first index to slot slots[0], the
+// second to slots[1] and so on. With no slots the order is reversed.
+// Term.Eval carries out the transpose as it contracts.
+//
+// Transpose of a matrix like
+// E(m.I(1, 0).D("j").U("i"))
+func (e ExpressionOf[T]) I(slots ...int) ExpressionOf[T] {
+	if e.t == nil {
+		e.pending = append(e.pending[:len(e.pending):len(e.pending)], slots)
+		return e
+	}
+	rank := len(e.t.dim)
+	if len(slots) == 0 {
+		for k := rank - 1; k >= 0; k-- {
+			slots = append(slots, k)
+		}
 	}
-	e.indices += indices
+	// Compose with any order declared before.
+	if e.slots != nil && len(slots) == rank {
+		composed := make([]int, rank)
+		for k, s := range slots {
+			composed[k] = -1
+			if s >= 0 && s < rank {
+				composed[k] = e.slots[s]
+			}
+		}
+		slots = composed
+	}
+	e.slots = slots
 	return e
 }
 
-func (e Expression) D(indices string) Expression {
-	for range indices {
-		e.signature += "d"
+// slot is the slot of the Tensor index j of the Expression goes to.
+func (e ExpressionOf[T]) slot(j int) int {
+	if e.slots == nil {
+		return j
+	}
+	return e.slots[j]
+}
+
+// slotSignature is the signature of the slots of the
+// Tensor in the order the Expression indexes them.
+func (e ExpressionOf[T]) slotSignature() string {
+	var sig string
+	for j := range e.t.signature {
+		sig += string(e.t.signature[e.slot(j)])
+	}
+	return sig
+}
+
+// permutation checks that the Expression sends
+// each index to a different slot of its Tensor.
+func (e ExpressionOf[T]) permutation() error {
+	if e.slots == nil {
+		return nil
+	}
+	rank := len(e.t.dim)
+	seen := make([]bool, rank)
+	for _, s := range e.slots {
+		if s < 0 || s >= rank || seen[s] {
+			return ErrIndexOutOfRange{Op: "permute", Position: s, Rank: rank}
+		}
+		seen[s] = true
+	}
+	if len(e.slots) != rank {
+		return ErrIndexOutOfRange{Op: "permute", Position: len(e.slots), Rank: rank}
+	}
+	return nil
+}
+
+// U adds upper indices to the Expression. Each letter is an
+// index, as are a letter and the digits after it, like "i1",
+// and anything in braces, like "{alpha}". If indices holds
+// spaces or commas they separate the labels instead, like
+// "alpha beta". See labels.go.
+func (e ExpressionOf[T]) U(indices string) ExpressionOf[T] {
+	return e.add(indices, 'u')
+}
+
+// D adds lower indices to the Expression, split into labels like U.
+func (e ExpressionOf[T]) D(indices string) ExpressionOf[T] {
+	return e.add(indices, 'd')
+}
+
+func (e ExpressionOf[T]) add(indices string, variance byte) ExpressionOf[T] {
+	labels := tokens(indices)
+	// Don't share a backing array with other Expressions.
+	e.indices = append(e.indices[:len(e.indices):len(e.indices)], labels...)
+	for range labels {
+		e.signature += string(variance)
 	}
-	e.indices += indices
 	return e
 }
 
+// String renders the indices of an expression in
+// abstract index notation, like "^ij_k" or "^{alpha}_k".
+func (e ExpressionOf[T]) String() string {
+	var ret string
+	for i := 0; i < len(e.indices); i++ {
+		switch {
+		case i > 0 && e.signature[i] == e.signature[i-1]:
+		case e.signature[i] == 'u':
+			ret += "^"
+		default:
+			ret += "_"
+		}
+		ret += renderLabel(e.indices[i])
+	}
+	return ret
+}
+
 // E wraps up a bunch of expressions into a term.
 func E(i ...Expression) Term {
-	return Term{i}
+	return Term{List: i}
+}
+
+// Using returns the term with a different contraction
+// order strategy. The default is Auto.
+func (term TermOf[T]) Using(s Strategy) TermOf[T] {
+	term.strategy = s
+	return term
+}
+
+// Checkpoint returns the term with a different policy
+// for materializing intermediates. The default is CostBased.
+func (term TermOf[T]) Checkpoint(p Policy) TermOf[T] {
+	term.policy = p
+	return term
+}
+
+// To returns the term with its free indices declared, in the
+// order the result should have them. Without To they come in the
+// order they are first written.
+//
+// Matrix transpose example
+// E(m.U("i").D("j")).To("ji")
+//
+// The indices are split into labels like Expression.U.
+func (term TermOf[T]) To(indices string) TermOf[T] {
+	term.output = tokens(indices)
+	term.ordered = true
+	return term
+}
+
+// Metric returns the term set to sum pairs of upper, or pairs
+// of lower, indices through the metric m. See metric.go.
+func (term TermOf[T]) Metric(m MetricOf[T]) TermOf[T] {
+	term.metric = &m
+	return term
+}
+
+// Permissive returns the term set to contract indices whatever
+// their variance, and whatever variance the Tensor gives their slots.
+//
+// By default Term.Eval is strict: every index must be written with
+// the variance of its slot, U for "u" and D for "d", and an index
+// summed over must appear once up and once down. With a Metric,
+// pairs of the same variance are summed through it instead.
+func (term TermOf[T]) Permissive() TermOf[T] {
+	term.permissive = true
+	return term
+}
+
+// Generalized returns the term set to read an index written more
+// than twice, like numpy's einsum does, as one coordinate shared
+// by every slot it labels. It is summed over unless it is an
+// output index, so with To an index may also appear twice or more
+// and still be free, giving diagonals and elementwise products.
+//
+// Hadamard product example
+// E(v.U("i"), w.U("i")).Generalized().To("i")
+//
+// Only indices summed over exactly twice, and not declared
+// with To, are checked for variance.
+func (term TermOf[T]) Generalized() TermOf[T] {
+	term.generalized = true
+	return term
+}
+
+// Cache returns the term with room for capacity elements in the
+// cache of each contraction. Zero or less turns caching off, which
+// saves memory at the cost of summing elements again on every read.
+func (term TermOf[T]) Cache(capacity int) TermOf[T] {
+	if capacity <= 0 {
+		capacity = -1
+	}
+	term.cache = capacity
+	return term
 }
 
 // Eval takes a list of expressions
@@ -200,206 +446,372 @@ func E(i ...Expression) Term {
 // and returns the resulting Tensor.
 //
 // Note that shmensor Tensors are lazy, so computation
-// is only performed when you call Reify().
+// is only performed when you call Reify(). Over a CheckedRing,
+// Eval reads every element instead, and returns the error of any
+// operation that fails.
+//
+// The order of contractions is planned before anything is
+// built; see plan.go. The free indices of the result always come
+// in the order they were written, or declared with To, whatever
+// the plan.
 //
 // Consider verbose mode boolean to explore what's happening.
-func (term Term) Eval() (Tensor, error, *Profiler) {
+func (term TermOf[T]) Eval() (TensorOf[T], error, *Profiler) {
+	return checked(term.eval)
+}
+
+func (term TermOf[T]) eval() (TensorOf[T], error, *Profiler) {
 	// Profiler
 	profiler := &Profiler{}
-	t := term.List
+	// Nested Evaluators that aren't Shapers can only tell their
+	// Shape by evaluating, so evaluate them once, here.
+	list, err := resolve(term.List, opaque[T], profiler)
+	if err != nil {
+		return TensorOf[T]{}, err, profiler
+	}
+	term.List = list
+	// Check everything before building anything.
+	if _, err := term.Shape(); err != nil {
+		return TensorOf[T]{}, err, profiler
+	}
+	t, err := resolve(term.List, every[T], profiler)
+	if err != nil {
+		return TensorOf[T]{}, err, profiler
+	}
+	capacity := term.cache
+	if capacity == 0 {
+		capacity = DefaultCacheCapacity
+	}
 
 	// Eval first tensors products
 	if len(t) == 0 {
-		return Tensor{}, nil, nil
+		return TensorOf[T]{}, nil, nil
 	}
 
-	/*
-		Evaluation subroutines
-	*/
+	l, err := term.layout(t)
+	if err != nil {
+		return TensorOf[T]{}, err, profiler
+	}
+	t, labels, count, free, output := l.list, l.labels, l.count, l.free, l.output
+	name := l.name
+	letter := func(k int) string { return renderLabel(l.letters[k]) }
 
-	// Products two tensors together, returns the result.
-	productSubroutine := func(e1, e2 *Expression) *Expression {
-		t := Product(*e1.t, *e2.t, profiler)
-		return &Expression{
-			&t,
-			e1.indices + e2.indices,
-			e1.signature + e2.signature,
+	// A lone expression only needs its repeated indices traced.
+	if len(t) == 1 {
+		e, err := contract(t, output, capacity, profiler)
+		if err != nil {
+			return TensorOf[T]{}, err, profiler
+		}
+		r := *e.t
+		r.workers = term.workers
+		if term.policy == Eager {
+			start := time.Now()
+			r = r.Materialize()
+			profiler.time(OpMaterialize, time.Since(start))
+		}
+		return r, nil, profiler
+	}
+
+	// Sum indices found only inside a single expression first,
+	// and keep one of each other index.
+	operands := make([]ExpressionOf[T], len(t))
+	shapes := make([][]int, len(t))
+	dim := make(map[int]int)
+	for i, e := range t {
+		keep := []string{}
+		for j, ch := range e.indices {
+			inside := 0
+			for _, x := range e.indices {
+				if x == ch {
+					inside++
+				}
+			}
+			if indexOf(e.indices, ch) == j && (inside < count[ch] || indexOf(output, ch) >= 0) {
+				keep = append(keep, ch)
+			}
+		}
+		e, err := contract([]ExpressionOf[T]{e}, keep, capacity, profiler)
+		if err != nil {
+			return TensorOf[T]{}, err, profiler
 		}
+		operands[i] = e
+		for j, ch := range e.indices {
+			shapes[i] = append(shapes[i], labels[ch])
+			dim[labels[ch]] = e.t.dim[j]
+		}
+	}
+
+	start := time.Now()
+	planner := newPlanner(shapes, dim, free)
+	p := planner.plan(term.strategy)
+	checkpoints := planner.checkpoints(p, term.policy, capacity, letter)
+	profiler.plan(planner.describe(p, letter), p.multiplies, p.adds, checkpoints)
+	profiler.time(OpPlan, time.Since(start))
+
+	// Contract each pair in turn. The last contraction
+	// puts the free indices back in the order they were written.
+	groups := make([][]int, len(operands))
+	for i := range groups {
+		groups[i] = []int{i}
+	}
+	for k, s := range p.steps {
+		group := append(append([]int{}, groups[s.a]...), groups[s.b]...)
+		out := name(planner.free(group))
+		if k == len(p.steps)-1 {
+			out = output
+		}
+		e, err := contract([]ExpressionOf[T]{operands[s.a], operands[s.b]}, out, capacity, profiler)
+		if err != nil {
+			return TensorOf[T]{}, err, profiler
+		}
+		r := *e.t
+		r.workers = term.workers
+		if checkpoints[k].Materialized {
+			start := time.Now()
+			r = r.Materialize()
+			profiler.time(OpMaterialize, time.Since(start))
+		}
+		e.t = &r
+		operands = append(operands, e)
+		groups = append(groups, group)
+	}
+	return *operands[len(operands)-1].t, nil, profiler
+}
+
+// A layout is what Term.Eval works out about a list of
+// Expressions before it contracts anything.
+type layout[T any] struct {
+	// The list, with any metric put in.
+	list []ExpressionOf[T]
+	// Every index label numbered for the planner,
+	// and how many times it appears.
+	labels  map[string]int
+	letters []string
+	count   map[string]int
+	// The free indices of the result in order.
+	free   []int
+	output []string
+	shape  shape
+}
+
+func (l layout[T]) name(ls []int) []string {
+	s := make([]string, len(ls))
+	for k, x := range ls {
+		s[k] = l.letters[x]
 	}
+	return s
+}
 
-	// Contract the first contraction you can in the expression.
-	// Return false if there was nothing to contract.
-	traceSubroutine := func(e *Expression) (bool, error) {
-		index := suffixarray.New([]byte(e.indices))
+// layout checks a list of Expressions, whose Tensors need nothing
+// but signatures and dimensions, and works out its labels and the
+// shape of the result.
+func (term TermOf[T]) layout(list []ExpressionOf[T]) (layout[T], error) {
+	if term.metric != nil {
+		list = term.metric.insert(list, term.output)
+	}
+	l := layout[T]{
+		list:   list,
+		labels: make(map[string]int),
+		count:  make(map[string]int),
+	}
+	// Give every index label a number for the planner, and note
+	// the free indices in the order they were written.
+	for _, e := range list {
+		for _, ch := range e.indices {
+			l.count[ch]++
+		}
+	}
+	for _, e := range list {
 		for _, ch := range e.indices {
-			offsets := index.Lookup([]byte(string(ch)), -1)
-			sort.Ints(offsets)
-			if len(offsets) > 2 {
-				return false, fmt.Errorf("%v, Index repeated more than twice", string(ch))
+			if l.count[ch] > 2 && !term.generalized {
+				return l, ErrIndexRepeated{Index: ch, Count: l.count[ch]}
 			}
-			if len(offsets) == 2 {
-				// Do the contraction logic.
-				traced, err := Trace(*e.t, offsets[0], offsets[1], profiler)
-				e.t = &traced
-				e.signature = e.signature[0:offsets[1]] + e.signature[offsets[1]+1:]
-				e.signature = e.signature[0:offsets[0]] + e.signature[offsets[0]+1:]
-				e.indices = e.indices[0:offsets[1]] + e.indices[offsets[1]+1:]
-				e.indices = e.indices[0:offsets[0]] + e.indices[offsets[0]+1:]
-				if err != nil {
-					return true, err
+			if _, ok := l.labels[ch]; !ok {
+				l.labels[ch] = len(l.letters)
+				l.letters = append(l.letters, ch)
+				if l.count[ch] == 1 {
+					l.free = append(l.free, l.labels[ch])
 				}
-				return true, nil
 			}
 		}
-		return false, nil
+	}
+	// Declared output indices must be the free ones, each once.
+	// A generalized term may keep any of its indices.
+	if term.ordered {
+		mismatch := ErrOutputMismatch{Output: render(term.output), Free: render(l.name(l.free))}
+		if len(term.output) != len(l.free) && !term.generalized {
+			return l, mismatch
+		}
+		l.free = l.free[:0:0]
+		for i, ch := range term.output {
+			if l.count[ch] == 0 || indexOf(term.output[:i], ch) >= 0 ||
+				(l.count[ch] != 1 && !term.generalized) {
+				return l, mismatch
+			}
+			l.free = append(l.free, l.labels[ch])
+		}
+	}
+	l.output = l.name(l.free)
+	if !term.permissive {
+		// Only pairs summed over have a variance to check.
+		paired := func(ch string) bool {
+			return l.count[ch] == 2 && indexOf(l.output, ch) < 0
+		}
+		if err := strict(list, paired); err != nil {
+			return l, err
+		}
 	}
 
-	/*
-		Evaluation strategy
-	*/
-	// Product everything together, then contract repeated indices until you can't.
-	rhs := &Expression{t[len(t)-1].t, t[len(t)-1].indices, t[len(t)-1].signature}
-	for i := len(t) - 1; i >= 0; i-- {
-		if i == len(t)-1 {
-			continue
+	// Every Expression must have elements of the same Ring.
+	for _, e := range list[1:] {
+		a, b := list[0].t.t, e.t.t
+		if a != nil && b != nil && !reflect.DeepEqual(a, b) {
+			return l, ErrTypeMismatch{Op: "contract", A: a, B: b}
+		}
+	}
+
+	// Every index must fit a slot, and have one dimension.
+	dim := make(map[string]int)
+	sig := make(map[string]byte)
+	for _, e := range list {
+		if err := e.permutation(); err != nil {
+			return l, err
+		}
+		if len(e.indices) != len(e.t.dim) {
+			return l, ErrSignatureMismatch{Op: "contract",
+				Index: render(e.indices), Signature: e.signature, Want: e.t.signature}
 		}
-		rhs = productSubroutine(&t[i], rhs)
-		//fmt.Printf("RHS pre: %v \n", rhs.indices)
-		for {
-			ok, err := traceSubroutine(rhs)
-			if err != nil {
-				panic(err)
+		for j, ch := range e.indices {
+			d, ok := dim[ch]
+			if ok && d != e.t.dim[e.slot(j)] {
+				return l, ErrDimensionMismatch{Op: "contract",
+					Index: ch, A: []int{d}, B: []int{e.t.dim[e.slot(j)]}}
 			}
 			if !ok {
-				break
+				dim[ch] = e.t.dim[e.slot(j)]
+				sig[ch] = e.t.signature[e.slot(j)]
 			}
 		}
-		//fmt.Printf("RHS post: %v \n", rhs.indices)
+	}
+	l.shape = shape{dim: []int{}, free: l.output, t: list[0].t.t}
+	for _, ch := range l.output {
+		l.shape.signature += string(sig[ch])
+		l.shape.dim = append(l.shape.dim, dim[ch])
+	}
+	return l, nil
+}
 
+// strict checks that every index is written with the variance of
+// its slot, and that every paired index is up once and down once.
+func strict[T any](list []ExpressionOf[T], paired func(string) bool) error {
+	first := make(map[string]ExpressionOf[T])
+	for _, e := range list {
+		if len(e.indices) != len(e.t.dim) || e.permutation() != nil {
+			// contract reports these.
+			continue
+		}
+		for j, ch := range e.indices {
+			if e.signature[j] != e.t.signature[e.slot(j)] {
+				return ErrSignatureMismatch{Op: "strict", Index: ch,
+					Signature: e.signature, Want: e.slotSignature()}
+			}
+			f, ok := first[ch]
+			if !paired(ch) {
+				continue
+			}
+			if !ok {
+				first[ch] = e
+				continue
+			}
+			if f.signature[indexOf(f.indices, ch)] == e.signature[j] {
+				return ErrVarianceMismatch{Index: ch, A: f.String(), B: e.String()}
+			}
+		}
 	}
-	return *rhs.t, nil, profiler
+	return nil
 }
 
 // More pedestrian eval functions
-func (e Expression) Eval() (Tensor, error, *Profiler) {
+func (e ExpressionOf[T]) Eval() (TensorOf[T], error, *Profiler) {
+	if e.t == nil {
+		return e.e.Eval()
+	}
 	return *e.t, nil, nil
 }
 
-func (t Tensor) Eval() (Tensor, error, *Profiler) {
+func (t TensorOf[T]) Eval() (TensorOf[T], error, *Profiler) {
 	return t, nil, nil
 }
 
-func (as Apply) Eval() (Tensor, error, *Profiler) {
-	apply := func(function Function, t Tensor) (Tensor, error) {
+func (as ApplyOf[T]) Eval() (TensorOf[T], error, *Profiler) {
+	return checked(as.eval)
+}
+
+func (as ApplyOf[T]) eval() (TensorOf[T], error, *Profiler) {
+	p := &Profiler{}
+	apply := func(function FunctionOf[T], t TensorOf[T]) (TensorOf[T], error) {
 		if !reflect.DeepEqual(t.t, function.t) {
-			return Tensor{}, fmt.Errorf("Tried to apply a function to a tensor"+
-				" of incompatible types. %v %v",
-				reflect.TypeOf(function.t), reflect.TypeOf(t.t))
+			return TensorOf[T]{}, ErrTypeMismatch{Op: "apply", A: function.t, B: t.t}
 		}
 
-		f := func(inner ...int) interface{} {
+		n := p.node("apply", OpApply, size(t.dim))
+		f := func(inner ...int) T {
+			defer n.elapsed(time.Now())
+			n.calls.Add(1)
 			i := make([]int, len(inner))
 			copy(i, inner)
 			// Assert they are the same type here
 			// TODO(xam)
 			return function.f(t.f(i...))
 		}
-		return Tensor{
-			f,
-			t.signature,
-			t.dim,
-			t.t,
+		return TensorOf[T]{
+			f:         f,
+			signature: t.signature,
+			dim:       t.dim,
+			t:         t.t,
 		}, nil
 	}
 
-	t, e1, p := as.E.Eval()
-	t2, e2 := apply(as.Func, t)
-	if e1 != nil || e2 != nil {
-		e2 = fmt.Errorf("One of 2 possible errors stemming from function application"+
-			"1)Evaling argument or 2)function application 1)%v, 2)%v, 3)%v", e1, e2)
+	t, err, p1 := as.E.Eval()
+	p.adopt(p1)
+	if err != nil {
+		return TensorOf[T]{}, fmt.Errorf("apply: %w", err), p
 	}
-	return t2, e2, p
-
+	t2, err := apply(as.Func, t)
+	return t2, err, p
 }
 
-func (ps Plus) Eval() (Tensor, error, *Profiler) {
-	plus := func(t1, t2 Tensor) (Tensor, error) {
-		if !reflect.DeepEqual(t1.dim, t2.dim) {
-			return Tensor{}, fmt.Errorf("Tried to add tensors of incompatible dimension. %v %v", t1, t2)
-		}
-		if !reflect.DeepEqual(t1.signature, t2.signature) {
-			return Tensor{}, fmt.Errorf("Tried to add tensors of incompatible signature. %v %v", t1, t2)
-		}
-		if !reflect.DeepEqual(t1.t, t2.t) {
-			return Tensor{}, fmt.Errorf("Tried to add tensors of incompatible type. %v %v",
-				reflect.TypeOf(t1.t), reflect.TypeOf(t2.t))
-		}
-		f := func(inner ...int) interface{} {
-			i := make([]int, len(inner))
-			copy(i, inner)
-			// Assert they are the same type here
-			// TODO(xam)
-			return t1.t.Add(t1.f(i...), t2.f(i...))
-		}
-		return Tensor{
-			f,
-			t1.signature,
-			t1.dim,
-			t1.t,
-		}, nil
-	}
-
-	t1, e1, p1 := ps.A.Eval()
-	t2, e2, p2 := ps.B.Eval()
-	p3 := &Profiler{}
-	if p1 != nil && p2 == nil {
-		p3 = p1
-	}
-	if p1 == nil && p2 != nil {
-		p3 = p2
-	}
-	if p1 != nil && p2 != nil {
-		p3 = &Profiler{
-			p1.Multiplies + p2.Multiplies,
-			p1.Adds + p2.Adds,
-			false,
-			p1.TraceCache + p2.TraceCache,
-		}
-	}
-	t3, e3 := plus(t1, t2)
-	if e1 != nil || e2 != nil || e3 != nil {
-		e3 = fmt.Errorf("One of 3 possible errors stemming from evaluating"+
-			"1)first term, 2)second term, or their 3)sum.\n 1)%v, 2)%v, 3)%v", e1, e2, e3)
-	}
-	return t3, e3, p3
+func (ps PlusOf[T]) Eval() (TensorOf[T], error, *Profiler) {
+	return checked(func() (TensorOf[T], error, *Profiler) {
+		return sum("plus", []EvaluatorOf[T]{ps.A, ps.B})
+	})
 }
 
-// Transpose swaps two tensor indices.
-func Transpose(t Tensor, a, b int) (Tensor, error) {
+// Transpose swaps two tensor indices, leaving the signature as it
+// is. To transpose inside a Term, see Expression.I.
+func Transpose[T any](t TensorOf[T], a, b int) (TensorOf[T], error) {
 	// assume a less than b
 	if b < a {
 		b, a = a, b
 	}
 
-	if b > len(t.signature)-1 || a < 0 {
-		panic("Trying to transpose bad indices.")
+	if a < 0 {
+		return TensorOf[T]{}, ErrIndexOutOfRange{Op: "transpose", Position: a, Rank: len(t.dim)}
 	}
-	g := func(i ...int) interface{} { //takes in dim 2 less
-		inner := make([]int, len(i))
-		copy(inner, i)
-		inner[a], inner[b] = inner[b], inner[a]
-		return t.f(inner...)
+	if b >= len(t.dim) {
+		return TensorOf[T]{}, ErrIndexOutOfRange{Op: "transpose", Position: b, Rank: len(t.dim)}
 	}
-
-	// Must swap dim
-	t.dim[a], t.dim[b] = t.dim[b], t.dim[a]
-
-	return Tensor{
-		g,
-		t.signature,
-		t.dim,
-		t.t,
-	}, nil
+	// Wire slot a of t to index b of the result and vice versa.
+	wires := make([]int, len(t.dim))
+	dim := make([]int, len(t.dim))
+	for i := range wires {
+		wires[i] = i
+		dim[i] = t.dim[i]
+	}
+	wires[a], wires[b] = b, a
+	dim[a], dim[b] = dim[b], dim[a]
+	n := (*Profiler)(nil).node(fmt.Sprintf("transpose %v,%v", a, b), OpContract, size(dim))
+	return fuse([]TensorOf[T]{t}, [][]int{wires}, nil, t.signature, dim, 0, n), nil
 }
 
 // Trace is a contraction on two indices.
@@ -407,89 +819,69 @@ func Transpose(t Tensor, a, b int) (Tensor, error) {
 // from accessing directly
 // and/or verify indices exist to contract
 // and are same dimensions.
-func Trace(t Tensor, a, b int, profiler *Profiler) (Tensor, error) {
+func Trace[T any](t TensorOf[T], a, b int, profiler *Profiler) (TensorOf[T], error) {
 
 	// assume a less than b
 	if b < a {
 		b, a = a, b
 	}
+	if a < 0 {
+		return TensorOf[T]{}, ErrIndexOutOfRange{Op: "trace", Position: a, Rank: len(t.dim)}
+	}
+	if b >= len(t.dim) {
+		return TensorOf[T]{}, ErrIndexOutOfRange{Op: "trace", Position: b, Rank: len(t.dim)}
+	}
 	if t.dim[a] != t.dim[b] {
-		log.Fatalf("trace error incompatible dims %v, %v", t.dim[a], t.dim[b])
-	}
-
-	cache := make(map[string]interface{})
-	g := func(i ...int) interface{} { //takes in dim 2 less
-		var sum interface{}
-		// Shift args for the 2 repeated indices.
-		inner := make([]int, len(i))
-		copy(inner, i)
-		inner = append(inner, 0, 0)
-		copy(inner[a+1:], inner[a:])
-		copy(inner[b+1:], inner[b:])
-
-		key := fmt.Sprintf("%v|%v|%v", inner, a, b)
-		if hit, ok := cache[key]; !ok {
-			for k := 0; k < t.dim[a]; k++ {
-				inner[a], inner[b] = k, k
-				if k == 0 {
-					sum = t.f(inner...)
-					continue
-				}
-				sum = t.t.Add(sum, t.f(inner...))
-				profiler.Adds += 1
-			}
-			cache[key] = sum
-			return sum
-		} else {
-			profiler.TraceCache += 1
-			return hit
-		}
-
+		return TensorOf[T]{}, ErrDimensionMismatch{Op: "trace",
+			A: []int{t.dim[a]}, B: []int{t.dim[b]}}
 	}
 
+	// Wire a and b to one summed index, everything else to the output.
+	var wires []int
 	var sig string
-	for i, elt := range t.signature {
-		if i != a && i != b {
-			sig += string(elt)
+	var d []int
+	for i := range t.dim {
+		if i == a || i == b {
+			wires = append(wires, ^0)
+			continue
 		}
+		wires = append(wires, len(d))
+		sig += string(t.signature[i])
+		d = append(d, t.dim[i])
 	}
-
-	// delete a and b from the dim array
-	d := make([]int, len(t.dim))
-	copy(d, t.dim)
-	d = append(d[:b], d[b+1:]...)
-	d = append(d[:a], d[a+1:]...)
-
-	return Tensor{
-		g,
-		sig,
-		d,
-		t.t,
-	}, nil
+	if d == nil {
+		d = []int{}
+	}
+	n := profiler.node(fmt.Sprintf("trace %v,%v", a, b), OpContract, size(d))
+	return fuse([]TensorOf[T]{t}, [][]int{wires}, []int{t.dim[a]}, sig, d,
+		DefaultCacheCapacity, n), nil
 }
 
-func Product(t1, t2 Tensor, profiler *Profiler) Tensor {
-	if profiler == nil {
-		profiler = &Profiler{}
+func Product[T any](t1, t2 TensorOf[T], profiler *Profiler) TensorOf[T] {
+	wires := [][]int{
+		make([]int, len(t1.dim)),
+		make([]int, len(t2.dim)),
+	}
+	for i := range t1.dim {
+		wires[0][i] = i
 	}
-	f := func(inner ...int) interface{} {
-		i := make([]int, len(inner))
-		copy(i, inner)
-		// Assert they are the same type here
-		// TODO(xam)
-		profiler.Multiplies += 1
-		return t1.t.Multiply(t1.f(i[0:len(t1.dim)]...),
-			t2.f(i[len(t1.dim):]...))
+	for i := range t2.dim {
+		wires[1][i] = len(t1.dim) + i
 	}
+	dim := make([]int, 0, len(t1.dim)+len(t2.dim))
+	dim = append(append(dim, t1.dim...), t2.dim...)
+	n := profiler.node("product", OpContract, size(dim))
+	return fuse([]TensorOf[T]{t1, t2}, wires, nil,
+		t1.signature+t2.signature, dim, 0, n)
+}
 
-	t2dim := make([]int, len(t2.dim))
-	copy(t2dim, t2.dim)
-	return Tensor{
-		f,
-		t1.signature + t2.signature,
-		append(t1.dim, t2dim...),
-		t1.t,
+// size is the number of elements of a tensor of dimension dim.
+func size(dim []int) int {
+	ret := 1
+	for _, d := range dim {
+		ret *= d
 	}
+	return ret
 }
 
 //func Eval(t1, t2 Tensor) Tensor {
@@ -523,7 +915,7 @@ func giveCoordinate(dim []int, i int) []int {
 // and 1 covariant index of dimension 4, Reify will produce a
 // 4 by (1*2*3)=6 matrix, with the indices sorted.
 // Do not treat this as a real matrix it's merely for convenience.
-func (t Tensor) Reify() [][]interface{} {
+func (t TensorOf[T]) Reify() [][]T {
 	coDim := 1
 	contraDim := 1
 	var co []int
@@ -539,13 +931,16 @@ func (t Tensor) Reify() [][]interface{} {
 		}
 	}
 	// Make kroeneker representation
-	twoD := make([][]interface{}, contraDim)
+	twoD := make([][]T, contraDim)
 	for i := 0; i < contraDim; i++ {
-		twoD[i] = make([]interface{}, coDim)
+		twoD[i] = make([]T, coDim)
 	}
 
-	for i := 0; i < contraDim; i++ {
-		for j := 0; j < coDim; j++ {
+	// Cells are split across t.workers goroutines; each cell
+	// is written by exactly one of them.
+	split(contraDim*coDim, t.workers, func(lo, hi int) {
+		for c := lo; c < hi; c++ {
+			i, j := c/coDim, c%coDim
 			coStack := giveCoordinate(co, j)
 			contraStack := giveCoordinate(contra, i)
 
@@ -563,6 +958,6 @@ func (t Tensor) Reify() [][]interface{} {
 			}
 			twoD[i][j] = t.f(merged...)
 		}
-	}
+	})
 	return twoD
 }
@@ -13,8 +13,13 @@
 package shmensor
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -246,6 +251,20 @@ func TestTrace(t *testing.T) {
 			"",
 			[]int{},
 			false},
+		{Product(*newVec(1, 2, 3), newRow(4, 5), &Profiler{}),
+			0,
+			1,
+			nil,
+			"",
+			nil,
+			true},
+		{Product(*newVec(1, 2, 3), newRow(4, 5, 6), &Profiler{}),
+			0,
+			2,
+			nil,
+			"",
+			nil,
+			true},
 	}
 	for _, tt := range table {
 		r, err := Trace(tt.tensor, tt.firstIndex, tt.secondIndex, &Profiler{})
@@ -253,6 +272,12 @@ func TestTrace(t *testing.T) {
 		if tt.producesError && err == nil {
 			t.Errorf("Trace attempt should have errored but did not.")
 		}
+		if err != nil {
+			if !tt.producesError {
+				t.Errorf("Unexpected error %v", err)
+			}
+			continue
+		}
 		// First check the actual numerical values are correct.
 		if !reflect.DeepEqual(r.Reify(), tt.reified) {
 			t.Errorf("Reified tensor value error: want %v, got %v", tt.reified, r.Reify())
@@ -459,7 +484,7 @@ func TestEval(t *testing.T) {
 			E(eps.D("ijk"), eps.D("pqr"),
 				det1.U("p").D("i"),
 				det1.U("q").D("j"),
-				det1.U("r").D("k")),
+				det1.U("r").D("k")).Permissive(),
 			[][]interface{}{{36}},
 			"",
 			[]int{},
@@ -484,6 +509,62 @@ func TestEval(t *testing.T) {
 			"u",
 			[]int{5},
 			false},
+
+		{
+			"Index repeated three times.",
+			E(newVec(1, 2).U("i"), newVec(3, 4).D("i"), newVec(5, 6).U("i")),
+			nil,
+			"",
+			nil,
+			true},
+
+		{
+			"Contracting indices of different dimension.",
+			E(newVec(1, 2).U("i"), newVec(3, 4, 5).D("i")).Permissive(),
+			nil,
+			"",
+			nil,
+			true},
+
+		{
+			"Tracing indices of different dimension.",
+			E(newMatrix([][]int{{1, 2, 3}, {4, 5, 6}}).U("i").D("i")),
+			nil,
+			"",
+			nil,
+			true},
+
+		{
+			"More indices than the tensor has.",
+			E(newVec(1, 2).U("ij")),
+			nil,
+			"",
+			nil,
+			true},
+
+		{
+			"Contracting two upper indices.",
+			E(newVec(1, 2).U("i"), newVec(3, 4).U("i")),
+			nil,
+			"",
+			nil,
+			true},
+
+		{
+			"Lowering an upper slot.",
+			E(newVec(1, 2).D("i")),
+			nil,
+			"",
+			nil,
+			true},
+
+		{
+			"Contracting two upper indices, permissively.",
+			E(newVec(1, 2).U("i"), newVec(3, 4).U("i")).Permissive(),
+			[][]interface{}{{11}},
+			"",
+			[]int{},
+			false},
 	}
 	for _, tt := range table {
 		tensor, err, _ := tt.tensorExpression.Eval()
@@ -491,6 +572,12 @@ func TestEval(t *testing.T) {
 		if tt.producesError && err == nil {
 			t.Errorf("On %v\n Trace attempt should have errored but did not.", tt.description)
 		}
+		if err != nil {
+			if !tt.producesError {
+				t.Errorf("On %v\n Unexpected error %v", tt.description, err)
+			}
+			continue
+		}
 		// First check the actual numerical values are correct.
 		if !reflect.DeepEqual(tensor.Reify(), tt.reified) {
 			t.Errorf("On %v\n Reified tensor value error: want %v, got %v", tt.description, tt.reified, tensor.Reify())
@@ -505,3 +592,1561 @@ func TestEval(t *testing.T) {
 		}
 	}
 }
+
+// Every contraction order should give the same answer, and
+// searching exhaustively should never be estimated to cost more
+// than folding from the right.
+func TestStrategy(t *testing.T) {
+	m := newMatrix([][]int{
+		{1, 2},
+		{3, 4},
+	})
+	table := []struct {
+		description string
+		term        Term
+		reified     [][]interface{}
+	}{
+		{
+			"Matrix vector products written out of order.",
+			E(m.U("a").D("b"), m.U("c").D("d"),
+				newVec(1, 2).U("b"), newVec(3, 4).U("d")),
+			[][]interface{}{{55}, {125}, {121}, {275}},
+		},
+		{
+			"Determinant, as in TestEval.",
+			E(eps.D("ijk"), eps.D("pqr"),
+				det1.U("p").D("i"),
+				det1.U("q").D("j"),
+				det1.U("r").D("k")).Permissive(),
+			[][]interface{}{{36}},
+		},
+	}
+	for _, tt := range table {
+		_, _, naive := tt.term.Using(RightToLeft).Eval()
+		for _, s := range []Strategy{Auto, Greedy, Exhaustive, RightToLeft} {
+			tensor, err, profiler := tt.term.Using(s).Eval()
+			if err != nil {
+				t.Errorf("On %v with %v: unexpected error %v", tt.description, s, err)
+				continue
+			}
+			if r := tensor.Reify(); !reflect.DeepEqual(r, tt.reified) {
+				t.Errorf("On %v with %v: got %v, want %v", tt.description, s, r, tt.reified)
+			}
+			if profiler.Stats().Path == "" {
+				t.Errorf("On %v with %v: profiler has no path", tt.description, s)
+			}
+			if s != Greedy && profiler.Stats().EstimatedMultiplies > naive.Stats().EstimatedMultiplies {
+				t.Errorf("On %v with %v: estimated %v multiplies, right to left estimated %v",
+					tt.description, s, profiler.Stats().EstimatedMultiplies, naive.Stats().EstimatedMultiplies)
+			}
+		}
+	}
+
+	// The last step of the path gives the indices in the order
+	// of the result, whichever order the plan found them in.
+	term := E(m.U("a").D("b"), newVec(1, 2).U("b"), m.U("c").D("d"), newVec(3, 4).U("d")).To("ca")
+	for _, s := range []Strategy{Auto, Greedy, Exhaustive, RightToLeft} {
+		_, _, profiler := term.Using(s).Eval()
+		stats := profiler.Stats()
+		last := stats.Checkpoints[len(stats.Checkpoints)-1].Step
+		if !strings.HasSuffix(stats.Path, "->ca") || !strings.HasSuffix(last, "->ca") {
+			t.Errorf("With %v: got path %v and last step %v, want them to end in ->ca", s, stats.Path, last)
+		}
+	}
+}
+
+// Contracting a pair should sum over all the shared indices
+// in one go, and the profiler should count exactly that work.
+func TestContract(t *testing.T) {
+	m := newMatrix([][]int{
+		{1, 2},
+		{3, 4},
+	})
+	row := newRow(3, 4)
+	table := []struct {
+		description string
+		a, b        Expression
+		reified     [][]interface{}
+		signature   string
+		multiplies  int
+		adds        int
+	}{
+		{
+			"Matrix multiplication.",
+			m.U("i").D("j"), m.U("j").D("k"),
+			[][]interface{}{{7, 10}, {15, 22}},
+			"ud",
+			8, 4,
+		},
+		{
+			"Both indices at once, the sum of squares.",
+			m.U("i").D("j"), m.U("i").D("j"),
+			[][]interface{}{{30}},
+			"",
+			4, 3,
+		},
+		{
+			"No shared index is a tensor product.",
+			newVec(1, 2).U("i"), row.D("j"),
+			[][]interface{}{{3, 4}, {6, 8}},
+			"ud",
+			4, 0,
+		},
+	}
+	for _, tt := range table {
+		profiler := &Profiler{}
+		e, err := Contract(tt.a, tt.b, profiler)
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tt.description, err)
+			continue
+		}
+		r, _, _ := e.Eval()
+		if !reflect.DeepEqual(r.Reify(), tt.reified) {
+			t.Errorf("On %v: got %v, want %v", tt.description, r.Reify(), tt.reified)
+		}
+		if r.Signature() != tt.signature {
+			t.Errorf("On %v: signature got %v, want %v", tt.description, r.Signature(), tt.signature)
+		}
+		if profiler.Stats().Multiplies != tt.multiplies || profiler.Stats().Adds != tt.adds {
+			t.Errorf("On %v: got %v multiplies and %v adds, want %v and %v", tt.description,
+				profiler.Stats().Multiplies, profiler.Stats().Adds, tt.multiplies, tt.adds)
+		}
+	}
+
+	// An output index the operands don't have.
+	_, err := contract([]Expression{m.U("i").D("j")}, []string{"k"}, 0, nil)
+	want := ErrOutputMismatch{Output: "k", Free: "ij"}
+	if err != want {
+		t.Errorf("Got %v, want %v", err, want)
+	}
+}
+
+// Dense tensors should read the same as lazy ones,
+// and mix with them anywhere.
+func TestDense(t *testing.T) {
+	lazy := newMatrix([][]int{
+		{1, 2, 3},
+		{4, 5, 6},
+	})
+	dense := NewDenseIntTensor([]int{1, 2, 3, 4, 5, 6}, nil, "ud", []int{2, 3})
+	// The same data read column major is the transpose.
+	transposed := NewDenseIntTensor([]int{1, 2, 3, 4, 5, 6}, []int{1, 3}, "ud", []int{3, 2})
+
+	table := []struct {
+		description string
+		e           Evaluator
+		reified     [][]interface{}
+	}{
+		{"Row major.", dense, lazy.Reify()},
+		{"Column major.", transposed, [][]interface{}{{1, 4}, {2, 5}, {3, 6}}},
+		{"Dense plus lazy.", Plus{dense, lazy}, [][]interface{}{{2, 4, 6}, {8, 10, 12}}},
+		{"Dense times lazy.", E(transposed.U("i").D("j"), lazy.U("j").D("k")),
+			[][]interface{}{{17, 22, 27}, {22, 29, 36}, {27, 36, 45}}},
+	}
+	for _, tt := range table {
+		r, err, _ := tt.e.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tt.description, err)
+			continue
+		}
+		if !reflect.DeepEqual(r.Reify(), tt.reified) {
+			t.Errorf("On %v: got %v, want %v", tt.description, r.Reify(), tt.reified)
+		}
+	}
+
+	// Materializing does the work once; reading it back does none.
+	product, _, profiler := E(lazy.U("i").D("j"), transposed.U("j").D("k")).Eval()
+	m := product.Materialize()
+	if !m.Dense() || product.Dense() {
+		t.Errorf("Materialize should give a dense tensor from a lazy one.")
+	}
+	if !reflect.DeepEqual(m.Reify(), product.Reify()) {
+		t.Errorf("Materialized %v, lazy %v", m.Reify(), product.Reify())
+	}
+	muls := profiler.Stats().Multiplies
+	m.Reify()
+	if profiler.Stats().Multiplies != muls {
+		t.Errorf("Reading a dense tensor did %v multiplies", profiler.Stats().Multiplies-muls)
+	}
+
+	// Bad layouts panic when the tensor is made.
+	bad := []struct {
+		description string
+		strides     []int
+		signature   string
+		dim         []int
+	}{
+		{"Too few strides.", []int{1}, "ud", []int{2, 3}},
+		{"Short signature.", nil, "u", []int{2, 3}},
+		{"Too little data.", nil, "ud", []int{3, 3}},
+		{"Negative stride.", []int{-3, 1}, "ud", []int{2, 3}},
+	}
+	for _, tc := range bad {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("On %v: no panic", tc.description)
+				}
+			}()
+			NewDenseIntTensor([]int{1, 2, 3, 4, 5, 6}, tc.strides, tc.signature, tc.dim)
+		}()
+	}
+}
+
+// Materialization policies should never change the answer.
+func TestCheckpoint(t *testing.T) {
+	m := newMatrix([][]int{
+		{1, 2},
+		{3, 4},
+	})
+	// Folded from the right, the vectors are multiplied together
+	// first and their product is read over and over.
+	term := E(m.U("a").D("b"), m.U("c").D("d"), m.U("e").D("f"),
+		newVec(1, 2).U("b"), newVec(3, 4).U("d"), newVec(5, 6).U("f")).Using(RightToLeft)
+	want := [][]interface{}{{935}, {2145}, {2125}, {4875}, {2057}, {4719}, {4675}, {10725}}
+
+	table := []struct {
+		policy       Policy
+		materialized []bool
+		dense        bool
+	}{
+		{Lazy, []bool{false, false, false, false, false}, false},
+		{Eager, []bool{true, true, true, true, true}, true},
+		{CostBased, []bool{false, true, false, false, false}, false},
+	}
+	for _, tt := range table {
+		r, err, profiler := term.Checkpoint(tt.policy).Eval()
+		if err != nil {
+			t.Errorf("With %v: unexpected error %v", tt.policy, err)
+			continue
+		}
+		if !reflect.DeepEqual(r.Reify(), want) {
+			t.Errorf("With %v: got %v, want %v", tt.policy, r.Reify(), want)
+		}
+		if r.Dense() != tt.dense {
+			t.Errorf("With %v: got dense %v, want %v", tt.policy, r.Dense(), tt.dense)
+		}
+		var got []bool
+		for _, c := range profiler.Stats().Checkpoints {
+			got = append(got, c.Materialized)
+		}
+		if !reflect.DeepEqual(got, tt.materialized) {
+			t.Errorf("With %v: materialized %v, want %v\n%v", tt.policy, got, tt.materialized, profiler)
+		}
+	}
+}
+
+// Reifying with many goroutines should give what one gives.
+// Run with -race to check the shared caches and counters.
+func TestParallel(t *testing.T) {
+	m := newMatrix([][]int{
+		{1, 2, 3},
+		{4, 5, 6},
+		{7, 8, 9},
+	})
+	term := E(m.U("a").D("b"), m.U("b").D("c"), m.U("c").D("d"),
+		newVec(1, 2, 3).U("d"), newVec(4, 5, 6).U("e"))
+
+	seq, _, p1 := term.Eval()
+	want := seq.Reify()
+	for _, workers := range []int{0, 2, 5, 100} {
+		par, err, p2 := term.Parallel(workers).Eval()
+		if err != nil {
+			t.Errorf("With %v workers: unexpected error %v", workers, err)
+			continue
+		}
+		if got := par.Reify(); !reflect.DeepEqual(got, want) {
+			t.Errorf("With %v workers: got %v, want %v", workers, got, want)
+		}
+		if got := par.Materialize().Reify(); !reflect.DeepEqual(got, want) {
+			t.Errorf("With %v workers, materialized: got %v, want %v", workers, got, want)
+		}
+		// Goroutines may race to fill the same cache entry,
+		// but never do less than the sequential work.
+		if p2.Stats().Multiplies < p1.Stats().Multiplies {
+			t.Errorf("With %v workers: %v multiplies, sequentially %v", workers, p2.Stats().Multiplies, p1.Stats().Multiplies)
+		}
+	}
+}
+
+// The profiler should add up the work of nested evaluators,
+// and break it down by the expressions that did it.
+func TestProfiler(t *testing.T) {
+	m := newMatrix([][]int{
+		{1, 2},
+		{3, 4},
+	})
+	product := E(m.U("i").D("j"), m.U("j").D("k"))
+	sum := Plus{product, product}
+	r, err, profiler := sum.Eval()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	r.Parallel(4).Reify()
+	r.Reify()
+
+	s := profiler.Stats()
+	want := Stats{
+		// Each product is 4 elements of 2 multiplies, computed once.
+		Multiplies: 16,
+		// One add per product element, and two reifies of 4 sums.
+		Adds: 8 + 8,
+		// Each product element is missed once then hit once;
+		// the sum is called 8 times.
+		Calls:       16 + 8,
+		CacheHits:   8,
+		CacheMisses: 8,
+		PeakSize:    4,
+	}
+	got := Stats{
+		Multiplies:  s.Multiplies,
+		Adds:        s.Adds,
+		Calls:       s.Calls,
+		CacheHits:   s.CacheHits,
+		CacheMisses: s.CacheMisses,
+		PeakSize:    s.PeakSize,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %+v, want %+v", got, want)
+	}
+
+	var nodes []string
+	for _, n := range s.Nodes {
+		nodes = append(nodes, n.Node)
+	}
+	wantNodes := []string{"^i_j*^j_k->^i_k", "^i_j*^j_k->^i_k", "plus"}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("Got nodes %v, want %v", nodes, wantNodes)
+	}
+	for _, op := range []Op{OpPlan, OpContract, OpPlus} {
+		if _, ok := s.Wall[op]; !ok {
+			t.Errorf("No time recorded for %v", op)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	m := newMatrix([][]int{
+		{1, 2},
+		{3, 4},
+	})
+	term := E(m.U("i").D("j"), m.U("j").D("k"))
+	want := [][]interface{}{{7, 10}, {15, 22}}
+	testCases := []struct {
+		name      string
+		term      Term
+		hits      int
+		misses    int
+		evictions int
+	}{
+		// Reified twice, every element is summed once.
+		{"default", term, 4, 4, 0},
+		// Reading in row major order with room for half
+		// of the elements, each one is evicted before it is
+		// read again.
+		{"half", term.Cache(2), 0, 8, 6},
+		{"off", term.Cache(0), 0, 0, 0},
+	}
+	for _, tc := range testCases {
+		r, err, profiler := tc.term.Eval()
+		if err != nil {
+			t.Fatalf("%v: unexpected error %v", tc.name, err)
+		}
+		for i := 0; i < 2; i++ {
+			if got := r.Reify(); !reflect.DeepEqual(got, want) {
+				t.Errorf("%v: got %v, want %v", tc.name, got, want)
+			}
+		}
+		s := profiler.Stats()
+		if s.CacheHits != tc.hits || s.CacheMisses != tc.misses || s.CacheEvictions != tc.evictions {
+			t.Errorf("%v: got %v hits, %v misses, %v evictions, want %v, %v, %v",
+				tc.name, s.CacheHits, s.CacheMisses, s.CacheEvictions,
+				tc.hits, tc.misses, tc.evictions)
+		}
+	}
+
+	// The clock gives recently read elements a second chance.
+	c := newCache[string](2, 10)
+	c.put(1, "a")
+	c.put(2, "b")
+	c.get(1)
+	if !c.put(3, "c") {
+		t.Errorf("Full cache did not evict")
+	}
+	if _, ok := c.get(1); !ok {
+		t.Errorf("Recently read element was evicted")
+	}
+	if _, ok := c.get(2); ok {
+		t.Errorf("Unread element was kept")
+	}
+	if newCache[string](0, 10) != nil {
+		t.Errorf("Zero capacity cache should cache nothing")
+	}
+}
+
+func TestReshape(t *testing.T) {
+	m := newMatrix([][]int{{1, 2}, {3, 4}})
+	if err := m.Reshape("dd"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if m.Signature() != "dd" {
+		t.Errorf("Got signature %v, want dd", m.Signature())
+	}
+	for _, sig := range []string{"d", "ddd", "dx"} {
+		err := m.Reshape(sig)
+		if _, ok := err.(ErrSignatureMismatch); !ok {
+			t.Errorf("Reshape to %q: got %v, want an ErrSignatureMismatch", sig, err)
+		}
+		if m.Signature() != "dd" {
+			t.Errorf("Reshape to %q changed signature to %v", sig, m.Signature())
+		}
+	}
+}
+
+func TestTranspose(t *testing.T) {
+	m := newMatrix([][]int{{1, 2}, {3, 4}})
+	r, err := Transpose(*m, 0, 1)
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	want := [][]interface{}{{1, 3}, {2, 4}}
+	if got := r.Reify(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	for _, ab := range [][2]int{{0, 2}, {-1, 1}} {
+		_, err := Transpose(*m, ab[0], ab[1])
+		if _, ok := err.(ErrIndexOutOfRange); !ok {
+			t.Errorf("Transpose %v: got %v, want an ErrIndexOutOfRange", ab, err)
+		}
+	}
+}
+
+// Errors deep in an Evaluator tree should be
+// visible to errors.Is and errors.As at the top.
+func TestErrors(t *testing.T) {
+	m := newMatrix([][]int{{1, 2}, {3, 4}})
+	r := newRealMatrix([][]float64{{1, 2}, {3, 4}})
+	lowered := *r
+	lowered.Reshape("dd")
+	v3 := NewDenseRealTensor([]float64{1, 2, 3}, nil, "u", []int{3})
+	double := NewRealScalar(2)
+	testCases := []struct {
+		description string
+		e           Evaluator
+		target      error
+	}{
+		{
+			"Repeated index in the second term of a sum.",
+			Plus{m, E(m.U("i").D("i"), m.U("i").D("j"))},
+			ErrIndexRepeated{},
+		},
+		{
+			"Sum of tensors of different signature under a function.",
+			Apply{double, Plus{r, lowered}},
+			ErrSignatureMismatch{},
+		},
+		{
+			"Function of the wrong type.",
+			Plus{m, Apply{double, m}},
+			ErrTypeMismatch{},
+		},
+		{
+			"Contraction of indices of different dimension.",
+			Apply{double, Apply{double, E(r.U("i").D("j"), v3.U("j"))}},
+			ErrDimensionMismatch{},
+		},
+		{
+			"Product of int and real tensors.",
+			E(m.U("i").D("j"), r.U("j").D("k")),
+			ErrTypeMismatch{},
+		},
+	}
+	for _, tc := range testCases {
+		_, err, _ := tc.e.Eval()
+		if !errors.Is(err, tc.target) {
+			t.Errorf("On %v: got %v, want an error of type %T", tc.description, err, tc.target)
+		}
+		target := reflect.New(reflect.TypeOf(tc.target))
+		if !errors.As(err, target.Interface()) {
+			t.Errorf("On %v: errors.As could not find a %T in %v", tc.description, tc.target, err)
+		}
+	}
+
+	if _, err := E(m.U("i").D("j"), r.U("j").D("k")).Shape(); !errors.Is(err, ErrTypeMismatch{}) {
+		t.Errorf("Got %v for the Shape of a product of int and real tensors, want an ErrTypeMismatch", err)
+	}
+
+	var repeated ErrIndexRepeated
+	_, err, _ := Plus{m, E(m.U("i").D("i"), m.U("i").D("j"))}.Eval()
+	if !errors.As(err, &repeated) || repeated.Index != "i" || repeated.Count != 3 {
+		t.Errorf("Got %#v, want index i repeated 3 times", repeated)
+	}
+	if errors.Is(err, ErrDimensionMismatch{}) {
+		t.Errorf("%v should not be a dimension mismatch", err)
+	}
+
+	var variance ErrVarianceMismatch
+	_, err, _ = Apply{double, E(r.U("i").D("j"), r.U("k").D("j"))}.Eval()
+	want := ErrVarianceMismatch{Index: "j", A: "^i_j", B: "^k_j"}
+	if !errors.As(err, &variance) || variance != want {
+		t.Errorf("Got %#v, want %#v", variance, want)
+	}
+}
+
+// Parsed notation should evaluate like the Evaluators built by hand.
+func TestParse(t *testing.T) {
+	m := newRealMatrix([][]float64{{1, 2}, {3, 4}})
+	upper, mixed := *m, *m
+	upper.Reshape("uu")
+	mixed.Reshape("du")
+	epsUp := eps
+	epsUp.Reshape("uuu")
+	v := newVec(2, 3, 4)
+	w := newVec(5, 6, 7)
+	n := Notation{
+		Tensors: map[string]Tensor{
+			"eps": eps, "epsUp": epsUp, "det1": det1, "v": *v, "w": *w,
+			"A": *m, "B": *m, "P": upper, "L": mixed,
+		},
+		Functions: map[string]Function{
+			"double": NewRealScalar(2),
+		},
+	}
+	testCases := []struct {
+		notation string
+		want     Evaluator
+	}{
+		{"eps_{ijk} v^j w^k",
+			E(eps.D("ijk"), v.U("j"), w.U("k"))},
+		{"epsUp^{ijk}eps_{pqr} det1^p_i det1^q_j det1^r_k",
+			E(epsUp.U("ijk"), eps.D("pqr"),
+				det1.U("p").D("i"), det1.U("q").D("j"), det1.U("r").D("k"))},
+		{"A^i_j B^j_k + A^i_k",
+			Plus{E(m.U("i").D("j"), m.U("j").D("k")), E(m.U("i").D("k"))}},
+		{" A^i_j+A^i_j +A^i_j ",
+			Plus{Plus{E(m.U("i").D("j")), E(m.U("i").D("j"))}, E(m.U("i").D("j"))}},
+		{"double(A^i_j B^j_k) + A^i_k",
+			Plus{Apply{NewRealScalar(2), E(m.U("i").D("j"), m.U("j").D("k"))}, E(m.U("i").D("k"))}},
+		{"P^{ij}",
+			E(upper.U("ij"))},
+		{"L_j^i",
+			E(mixed.D("j").U("i"))},
+		{"A^i_j B^j_k -> ki",
+			E(m.U("i").D("j"), m.U("j").D("k")).To("ki")},
+		{"A^i_j + double(B^i_j) -> ji",
+			Plus{E(m.U("i").D("j")).To("ji"), Apply{NewRealScalar(2), E(m.U("i").D("j")).To("ji")}}},
+	}
+	for _, tc := range testCases {
+		e, err := n.Parse(tc.notation)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error %v", tc.notation, err)
+			continue
+		}
+		got, err, _ := e.Eval()
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error evaluating %v", tc.notation, err)
+			continue
+		}
+		want, _, _ := tc.want.Eval()
+		if !reflect.DeepEqual(got.Reify(), want.Reify()) || got.Signature() != want.Signature() {
+			t.Errorf("Parse(%q): got %v %v, want %v %v", tc.notation,
+				got.Signature(), got.Reify(), want.Signature(), want.Reify())
+		}
+	}
+
+	errorCases := []struct {
+		notation string
+		column   int
+	}{
+		{"", 1},
+		{"A^i_", 5},
+		{"A^{ij", 6},
+		{"A^{}", 4},
+		{"A^i +", 6},
+		{"A^i C_i", 5},
+		{"A^i_j double(B^j_k)", 7},
+		{"double(B^j_k) A^i_j", 15},
+		{"double(B^j_k", 13},
+		{"triple(B^j_k)", 1},
+		{"A^i)", 4},
+		{"A^1", 3},
+		{"A^i_j - B_i", 7},
+		{"A^i_j -> ij +", 13},
+		{"A^{i$}", 5},
+		{"A^{i, 2b}_j", 7},
+	}
+	for _, tc := range errorCases {
+		_, err := n.Parse(tc.notation)
+		var syntax *SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Errorf("Parse(%q): got %v, want a SyntaxError", tc.notation, err)
+			continue
+		}
+		if syntax.Column != tc.column {
+			t.Errorf("Parse(%q): got error at column %v, want %v: %v",
+				tc.notation, syntax.Column, tc.column, err)
+		}
+	}
+
+	// Parse is a shorthand for tensors alone.
+	if _, err := Parse("v^i", map[string]Tensor{"v": *v}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestTo(t *testing.T) {
+	m := newMatrix([][]int{{1, 2, 3}, {4, 5, 6}})
+	v := newVec(1, 2)
+	row := newRow(3, 4)
+	testCases := []struct {
+		description string
+		term        Term
+		reified     [][]interface{}
+		signature   string
+		dimension   []int
+	}{
+		// Reify lays out upper indices down and lower ones across,
+		// so only the signature and dimension show the transpose.
+		{"Transpose of a matrix.",
+			E(m.U("i").D("j")).To("ji"),
+			[][]interface{}{{1, 2, 3}, {4, 5, 6}},
+			"du",
+			[]int{3, 2}},
+		{"Product of matrices, transposed.",
+			E(m.U("i").D("j"), newMatrix([][]int{{1}, {1}, {1}}).U("j").D("k")).To("ki"),
+			[][]interface{}{{6}, {15}},
+			"du",
+			[]int{1, 2}},
+		{"Outer product of vectors, in written order.",
+			E(v.U("i"), newVec(3, 4).U("j")).To("ij"),
+			[][]interface{}{{3}, {4}, {6}, {8}},
+			"uu",
+			[]int{2, 2}},
+		{"Outer product of vectors, reversed.",
+			E(v.U("i"), newVec(3, 4).U("j")).To("ji"),
+			[][]interface{}{{3}, {6}, {4}, {8}},
+			"uu",
+			[]int{2, 2}},
+		{"Scalar.",
+			E(v.U("i"), row.D("i")).To(""),
+			[][]interface{}{{11}},
+			"",
+			[]int{}},
+	}
+	for _, tc := range testCases {
+		r, err, _ := tc.term.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tc.description, err)
+			continue
+		}
+		if got := r.Reify(); !reflect.DeepEqual(got, tc.reified) {
+			t.Errorf("On %v: got %v, want %v", tc.description, got, tc.reified)
+		}
+		if r.Signature() != tc.signature {
+			t.Errorf("On %v: got signature %v, want %v", tc.description, r.Signature(), tc.signature)
+		}
+		if !reflect.DeepEqual(r.Dimension(), tc.dimension) {
+			t.Errorf("On %v: got dimension %v, want %v", tc.description, r.Dimension(), tc.dimension)
+		}
+	}
+
+	// Only i and k are free.
+	for _, out := range []string{"i", "ijk", "ij", "ii", "xk"} {
+		_, err, _ := E(m.U("i").D("j"), newMatrix([][]int{{1}, {1}, {1}}).U("j").D("k")).To(out).Eval()
+		if !errors.Is(err, ErrOutputMismatch{}) {
+			t.Errorf("To(%q): got %v, want an ErrOutputMismatch", out, err)
+		}
+	}
+}
+
+func TestPermute(t *testing.T) {
+	m := newMatrix([][]int{{1, 2, 3}, {4, 5, 6}})
+	outer := Product(*newVec(1, 2), newVec(3, 4, 5).Materialize(), nil)
+	testCases := []struct {
+		description string
+		term        Term
+		reified     [][]interface{}
+		signature   string
+		dimension   []int
+	}{
+		{"Outer product with its slots reversed.",
+			E(outer.I().U("ji")),
+			[][]interface{}{{3}, {6}, {4}, {8}, {5}, {10}},
+			"uu",
+			[]int{3, 2}},
+		{"Transposed matrix times a vector.",
+			E(m.I().D("j").U("i"), newVec(1, 1, 1).U("j")),
+			[][]interface{}{{6}, {15}},
+			"u",
+			[]int{2}},
+		{"Reversing twice changes nothing.",
+			E(m.I().I().U("i").D("j")),
+			[][]interface{}{{1, 2, 3}, {4, 5, 6}},
+			"ud",
+			[]int{2, 3}},
+		{"Explicit slots.",
+			E(outer.I(1, 0).U("ji")),
+			[][]interface{}{{3}, {6}, {4}, {8}, {5}, {10}},
+			"uu",
+			[]int{3, 2}},
+	}
+	for _, tc := range testCases {
+		r, err, _ := tc.term.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tc.description, err)
+			continue
+		}
+		if got := r.Reify(); !reflect.DeepEqual(got, tc.reified) {
+			t.Errorf("On %v: got %v, want %v", tc.description, got, tc.reified)
+		}
+		if r.Signature() != tc.signature {
+			t.Errorf("On %v: got signature %v, want %v", tc.description, r.Signature(), tc.signature)
+		}
+		if !reflect.DeepEqual(r.Dimension(), tc.dimension) {
+			t.Errorf("On %v: got dimension %v, want %v", tc.description, r.Dimension(), tc.dimension)
+		}
+	}
+
+	for _, slots := range [][]int{{0}, {0, 0}, {1, 2}, {0, 1, 2}} {
+		_, err, _ := E(m.I(slots...).U("i").D("j")).Eval()
+		if !errors.Is(err, ErrIndexOutOfRange{}) {
+			t.Errorf("I(%v): got %v, want an ErrIndexOutOfRange", slots, err)
+		}
+	}
+
+	// Transpose should leave the caller's tensor alone.
+	r, err := Transpose(outer, 0, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(outer.Dimension(), []int{2, 3}) {
+		t.Errorf("Transpose changed the dimension of its argument to %v", outer.Dimension())
+	}
+	want, _, _ := E(outer.I().U("ji")).Eval()
+	if !reflect.DeepEqual(r.Reify(), want.Reify()) {
+		t.Errorf("Transpose gave %v, I gave %v", r.Reify(), want.Reify())
+	}
+}
+
+func TestMetric(t *testing.T) {
+	g := newMatrix([][]int{{2, 1}, {1, 1}})
+	g.Reshape("dd")
+	inverse := newMatrix([][]int{{1, -1}, {-1, 2}})
+	inverse.Reshape("uu")
+	metric, err := NewMetric(*g, *inverse)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	a, b := newRow(1, 2), newRow(3, 4)
+	m := newMatrix([][]int{{1, 2}, {3, 4}})
+	upper := *m
+	upper.Reshape("uu")
+
+	testCases := []struct {
+		description string
+		term        Term
+		want        Term
+	}{
+		{"Inner product of vectors.",
+			E(newVec(1, 2).U("i"), newVec(3, 4).U("i")).Metric(metric),
+			E(newVec(1, 2).U("i"), g.D("ij"), newVec(3, 4).U("j"))},
+		{"Inner product of covectors.",
+			E(a.D("i"), b.D("i")).Metric(metric),
+			E(a.D("i"), inverse.U("ij"), b.D("j"))},
+		{"Trace of a matrix with both indices up.",
+			E(upper.U("i").U("i")).Metric(metric),
+			E(upper.U("ij"), g.D("ij"))},
+		{"Opposite variance needs no metric.",
+			E(m.U("i").D("j"), newVec(3, 4).U("j")).Metric(metric),
+			E(m.U("i").D("j"), newVec(3, 4).U("j"))},
+	}
+	for _, tc := range testCases {
+		got, err, _ := tc.term.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tc.description, err)
+			continue
+		}
+		want, _, _ := tc.want.Eval()
+		if !reflect.DeepEqual(got.Reify(), want.Reify()) {
+			t.Errorf("On %v: got %v, want %v", tc.description, got.Reify(), want.Reify())
+		}
+	}
+
+	raised, err := metric.Raise(a, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if got, want := raised.Reify(), [][]interface{}{{-1}, {3}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Raised %v to %v, want %v", a.Reify(), got, want)
+	}
+	lowered, err := metric.Lower(raised, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(lowered.Reify(), a.Reify()) || lowered.Signature() != "d" {
+		t.Errorf("Lowered back to %v %v, want %v d", lowered.Signature(), lowered.Reify(), a.Reify())
+	}
+	// Lowering the first slot of a matrix keeps the second in place.
+	mixed, err := metric.Lower(*m, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	want, _, _ := E(g.D("ai"), m.U("i").D("b")).Eval()
+	if !reflect.DeepEqual(mixed.Reify(), want.Reify()) || mixed.Signature() != "dd" {
+		t.Errorf("Got %v %v, want %v dd", mixed.Signature(), mixed.Reify(), want.Reify())
+	}
+
+	if _, err := metric.Raise(*m, 0); !errors.Is(err, ErrSignatureMismatch{}) {
+		t.Errorf("Raising an upper index: got %v, want an ErrSignatureMismatch", err)
+	}
+	if _, err := metric.Lower(*m, 2); !errors.Is(err, ErrIndexOutOfRange{}) {
+		t.Errorf("Lowering a missing slot: got %v, want an ErrIndexOutOfRange", err)
+	}
+	if _, err := NewMetric(*inverse, *g); !errors.Is(err, ErrSignatureMismatch{}) {
+		t.Errorf("Swapped metric: got %v, want an ErrSignatureMismatch", err)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	m := newMatrix([][]int{{1, 2}, {3, 4}})
+	v := newVec(1, 2)
+	row := newRow(1, 1)
+	testCases := []struct {
+		description string
+		term        Term
+		want        Term
+	}{
+		{"Greek indices.",
+			E(m.U("μ").D("ν"), v.U("ν")),
+			E(m.U("i").D("j"), v.U("j"))},
+		{"Letters with digits.",
+			E(m.U("i1").D("i2"), v.U("i2")),
+			E(m.U("i").D("j"), v.U("j"))},
+		{"Words in braces.",
+			E(m.U("{row}").D("{col}"), v.U("{col}")),
+			E(m.U("i").D("j"), v.U("j"))},
+		{"Words separated by spaces.",
+			E(m.U("{alpha}").D("{beta}"), m.U("{beta}").D("{gamma}")).To("gamma alpha"),
+			E(m.U("i").D("j"), m.U("j").D("k")).To("ki")},
+		{"Letters with digits, run together.",
+			E(row.D("i1"), m.U("i1").D("j1"), v.U("j1")),
+			E(row.D("i"), m.U("i").D("j"), v.U("j"))},
+	}
+	for _, tc := range testCases {
+		got, err, _ := tc.term.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tc.description, err)
+			continue
+		}
+		want, _, _ := tc.want.Eval()
+		if !reflect.DeepEqual(got.Reify(), want.Reify()) || got.Signature() != want.Signature() {
+			t.Errorf("On %v: got %v %v, want %v %v", tc.description,
+				got.Signature(), got.Reify(), want.Signature(), want.Reify())
+		}
+	}
+
+	// Expressions print their labels so they read back the same way.
+	for _, tc := range []struct {
+		e    Expression
+		want string
+	}{
+		{m.U("μ").D("ν"), "^μ_ν"},
+		{m.U("i1").D("j"), "^i1_j"},
+		{m.U("{alpha}").D("{beta}"), "^{alpha}_{beta}"},
+	} {
+		if got := tc.e.String(); got != tc.want {
+			t.Errorf("Got %v, want %v", got, tc.want)
+		}
+	}
+
+	// More indices than there are ASCII letters.
+	v = newVec(1, 1)
+	var list []Expression
+	for k := 0; k < 60; k++ {
+		list = append(list, v.U(fmt.Sprintf("i%v", k)), row.D(fmt.Sprintf("i%v", k)))
+	}
+	r, err, _ := E(list...).Eval()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if got, want := r.Reify(), [][]interface{}{{1 << 60}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+
+	// The parser reads labels the same way.
+	n := Notation{Tensors: map[string]Tensor{"A": *m, "v": *v}}
+	for _, s := range []string{"A^μ_ν v^ν", "A^i1_i2 v^i2", "A^{{alpha}}_{{beta}} v^{{beta}}"} {
+		e, err := n.Parse(s)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error %v", s, err)
+			continue
+		}
+		got, err, _ := e.Eval()
+		want, _, _ := E(m.U("i").D("j"), v.U("j")).Eval()
+		if err != nil || !reflect.DeepEqual(got.Reify(), want.Reify()) {
+			t.Errorf("Parse(%q): got %v %v, want %v", s, got.Reify(), err, want.Reify())
+		}
+	}
+}
+
+func TestGeneralized(t *testing.T) {
+	v := newVec(1, 2, 3)
+	w := newVec(4, 5, 6)
+	m := newMatrix([][]int{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}})
+	testCases := []struct {
+		description string
+		term        Term
+		reified     [][]interface{}
+		signature   string
+		dimension   []int
+	}{
+		{"Hadamard product.",
+			E(v.U("i"), w.U("i")).Generalized().To("i"),
+			[][]interface{}{{4}, {10}, {18}},
+			"u",
+			[]int{3}},
+		{"Hadamard product, as with a Dirac delta.",
+			E(newIntDirac3(3).U("a").D("b").D("c"), v.U("b"), w.U("c")),
+			[][]interface{}{{4}, {10}, {18}},
+			"u",
+			[]int{3}},
+		{"Sum of an elementwise product of three vectors.",
+			E(v.U("i"), w.U("i"), v.U("i")).Generalized(),
+			[][]interface{}{{4 + 20 + 54}},
+			"",
+			[]int{}},
+		{"Elementwise product of three vectors.",
+			E(v.U("i"), w.U("i"), v.U("i")).Generalized().To("i"),
+			[][]interface{}{{4}, {20}, {54}},
+			"u",
+			[]int{3}},
+		{"Diagonal of a matrix.",
+			E(m.U("i").D("i")).Generalized().To("i"),
+			[][]interface{}{{1}, {5}, {9}},
+			"u",
+			[]int{3}},
+		{"Rows of a matrix scaled elementwise.",
+			E(v.U("i"), m.U("i").D("j")).Generalized().To("ij"),
+			[][]interface{}{{1, 2, 3}, {8, 10, 12}, {21, 24, 27}},
+			"ud",
+			[]int{3, 3}},
+		{"Matrix times vector times a shared vector.",
+			E(m.U("i").D("j"), v.U("j"), w.U("i"), v.U("i")).Generalized().To("i"),
+			[][]interface{}{{14 * 4}, {32 * 10}, {50 * 18}},
+			"u",
+			[]int{3}},
+	}
+	for _, tc := range testCases {
+		for _, s := range []Strategy{Auto, Greedy, RightToLeft} {
+			r, err, _ := tc.term.Using(s).Eval()
+			if err != nil {
+				t.Errorf("On %v with %v: unexpected error %v", tc.description, s, err)
+				continue
+			}
+			if got := r.Reify(); !reflect.DeepEqual(got, tc.reified) {
+				t.Errorf("On %v with %v: got %v, want %v", tc.description, s, got, tc.reified)
+			}
+			if r.Signature() != tc.signature {
+				t.Errorf("On %v with %v: got signature %v, want %v", tc.description, s, r.Signature(), tc.signature)
+			}
+			if !reflect.DeepEqual(r.Dimension(), tc.dimension) {
+				t.Errorf("On %v with %v: got dimension %v, want %v", tc.description, s, r.Dimension(), tc.dimension)
+			}
+		}
+	}
+
+	// Without Generalized the same terms are errors.
+	if _, err, _ := E(v.U("i"), w.U("i"), v.U("i")).Eval(); !errors.Is(err, ErrIndexRepeated{}) {
+		t.Errorf("Got %v, want an ErrIndexRepeated", err)
+	}
+	if _, err, _ := E(v.U("i"), w.U("i")).To("i").Eval(); !errors.Is(err, ErrOutputMismatch{}) {
+		t.Errorf("Got %v, want an ErrOutputMismatch", err)
+	}
+	// Output indices must still be in the term, each once.
+	for _, out := range []string{"ii", "ij"} {
+		_, err, _ := E(v.U("i"), w.U("i")).Generalized().To(out).Eval()
+		if !errors.Is(err, ErrOutputMismatch{}) {
+			t.Errorf("To(%q): got %v, want an ErrOutputMismatch", out, err)
+		}
+	}
+	// A pair summed over is still checked for variance.
+	if _, err, _ := E(v.U("i"), w.U("i")).Generalized().Eval(); !errors.Is(err, ErrVarianceMismatch{}) {
+		t.Errorf("Got %v, want an ErrVarianceMismatch", err)
+	}
+}
+
+func TestSum(t *testing.T) {
+	a := newMatrix([][]int{{1, 2}, {3, 4}})
+	// b_j^i holds the matrix {{10, 20}, {30, 40}} indexed i, j.
+	b := NewIntTensor(func(i ...int) int {
+		return [][]int{{10, 20}, {30, 40}}[i[1]][i[0]]
+	}, "du", []int{2, 2})
+	v := newVec(1, 2)
+	w := newVec(3, 4, 5)
+	testCases := []struct {
+		description string
+		sum         Evaluator
+		reified     [][]interface{}
+		signature   string
+	}{
+		{"Slots in a different order.",
+			Plus{E(a.U("i").D("j")), E(b.D("j").U("i"))},
+			[][]interface{}{{11, 22}, {33, 44}},
+			"ud"},
+		{"Expressions.",
+			Plus{a.U("i").D("j"), b.D("j").U("i")},
+			[][]interface{}{{11, 22}, {33, 44}},
+			"ud"},
+		{"Outer products written in a different order.",
+			Plus{E(v.U("i"), w.U("j")), E(w.U("j"), v.U("i"))},
+			[][]interface{}{{6}, {8}, {10}, {12}, {16}, {20}},
+			"uu"},
+		{"Three terms.",
+			Sum{E(a.U("i").D("j")), E(b.D("j").U("i")), E(a.U("i").D("j"))},
+			[][]interface{}{{12, 24}, {36, 48}},
+			"ud"},
+		{"Nested sums.",
+			Sum{Plus{E(b.D("j").U("i")), E(a.U("i").D("j"))}, E(a.U("i").D("j"))},
+			[][]interface{}{{12, 24}, {36, 48}},
+			"du"},
+		{"A Tensor is added by position.",
+			Plus{*a, E(a.U("i").D("j"))},
+			[][]interface{}{{2, 4}, {6, 8}},
+			"ud"},
+		{"Linear combination.",
+			Sum{E(a.U("i").D("j")), Scaled{2, E(b.D("j").U("i"))}, Negated{E(a.U("i").D("j"))}},
+			[][]interface{}{{20, 40}, {60, 80}},
+			"ud"},
+		{"Subtraction.",
+			Minus(E(a.U("i").D("j")), E(b.D("j").U("i"))),
+			[][]interface{}{{-9, -18}, {-27, -36}},
+			"ud"},
+		{"Symbolic subtraction.",
+			Minus(newStringMatrix([][]string{{"a"}}), newStringMatrix([][]string{{"b"}})),
+			[][]interface{}{{"a + -(b)"}},
+			"ud"},
+	}
+	for _, tc := range testCases {
+		r, err, _ := tc.sum.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tc.description, err)
+			continue
+		}
+		if got := r.Reify(); !reflect.DeepEqual(got, tc.reified) {
+			t.Errorf("On %v: got %v, want %v", tc.description, got, tc.reified)
+		}
+		if r.Signature() != tc.signature {
+			t.Errorf("On %v: got signature %v, want %v", tc.description, r.Signature(), tc.signature)
+		}
+	}
+
+	errorCases := []struct {
+		description string
+		sum         Evaluator
+		want        error
+	}{
+		{"Different indices.",
+			Plus{E(a.U("i").D("j")), E(a.U("i").D("k"))},
+			ErrIndexMismatch{}},
+		{"Different number of indices.",
+			Sum{E(a.U("i").D("j")), E(v.U("i"))},
+			ErrIndexMismatch{}},
+		{"Different variance.",
+			Plus{E(a.U("i").D("j")), E(a.U("j").D("i"))},
+			ErrSignatureMismatch{}},
+		{"Different dimension.",
+			Plus{E(v.U("i"), w.U("j")), E(v.U("j"), w.U("i"))},
+			ErrDimensionMismatch{}},
+		{"No terms.", Sum{}, ErrEmptySum{}},
+		{"Real coefficient of an int tensor.", Scaled{C: 0.5, E: E(a.U("i").D("j"))}, ErrTypeMismatch{}},
+	}
+	for _, tc := range errorCases {
+		_, err, _ := tc.sum.Eval()
+		if !errors.Is(err, tc.want) {
+			t.Errorf("On %v: got %v, want a %T", tc.description, err, tc.want)
+		}
+		if _, err := ShapeOf(tc.sum); !errors.Is(err, tc.want) {
+			t.Errorf("On %v: got %v for the Shape, want a %T", tc.description, err, tc.want)
+		}
+	}
+	// Mismatches are reported by label.
+	_, err, _ := Plus{E(a.U("i").D("j")), E(a.U("j").D("i"))}.Eval()
+	var signature ErrSignatureMismatch
+	if !errors.As(err, &signature) || signature.Index != "i" {
+		t.Errorf("Got %v, want an ErrSignatureMismatch on index i", err)
+	}
+
+	// The Profiler of a sum takes in those of all its terms.
+	_, _, p := Sum{E(a.U("i").D("j"), a.U("j").D("k")), Scaled{3, E(a.U("i").D("k"))},
+		Negated{E(a.U("i").D("k"))}}.Eval()
+	var nodes []string
+	for _, n := range p.Stats().Nodes {
+		nodes = append(nodes, n.Node)
+	}
+	wantNodes := []string{"^i_j*^j_k->^i_k", "scale", "negate", "sum"}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("Got nodes %v, want %v", nodes, wantNodes)
+	}
+}
+
+func TestNested(t *testing.T) {
+	a := newMatrix([][]int{{1, 2}, {3, 4}})
+	b := newMatrix([][]int{{0, 1}, {1, 0}})
+	v := newVec(1, 2)
+	w := newVec(3, 4)
+	sum := Plus{E(a.U("i").D("j")), E(b.U("i").D("j"))}
+	testCases := []struct {
+		description string
+		term        Term
+		want        Evaluator
+	}{
+		{"A sum times a vector.",
+			E(sum.U("i").D("j"), v.U("j")),
+			Plus{E(a.U("i").D("j"), v.U("j")), E(b.U("i").D("j"), v.U("j"))}},
+		{"A term relabeled.",
+			E(E(a.U("i").D("j"), a.U("j").D("k")).U("x").D("y"), v.U("y")),
+			E(a.U("i").D("j"), a.U("j").D("k"), v.U("k"))},
+		{"A linear combination.",
+			E(Minus(Scaled{3, E(a.U("i").D("j"))}, sum).U("i").D("j"), v.U("j")),
+			Sum{Scaled{3, E(a.U("i").D("j"), v.U("j"))}, Negated{E(sum.U("i").D("j"), v.U("j"))}}},
+		{"Nested twice.",
+			E(E(sum.U("i").D("j"), a.U("j").D("k")).U("i").D("k"), v.U("k")),
+			E(sum.U("i").D("j"), a.U("j").D("k"), v.U("k"))},
+		{"Slots ordered before evaluation.",
+			E(E(v.U("i"), w.U("j")).U("ab").I()),
+			E(w.U("a"), v.U("b"))},
+	}
+	for _, tc := range testCases {
+		got, err, _ := tc.term.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tc.description, err)
+			continue
+		}
+		want, err, _ := tc.want.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tc.description, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Reify(), want.Reify()) || got.Signature() != want.Signature() {
+			t.Errorf("On %v: got %v %v, want %v %v", tc.description,
+				got.Signature(), got.Reify(), want.Signature(), want.Reify())
+		}
+	}
+
+	// Errors of nested Evaluators come up.
+	_, err, _ := E(E(v.U("i"), w.U("i"), v.U("i")).U("x")).Eval()
+	if !errors.Is(err, ErrIndexRepeated{}) {
+		t.Errorf("Got %v, want an ErrIndexRepeated", err)
+	}
+	_, err, _ = E(Plus{E(a.U("i").D("j")), E(v.U("i"))}.U("i").D("j")).Eval()
+	if !errors.Is(err, ErrIndexMismatch{}) {
+		t.Errorf("Got %v, want an ErrIndexMismatch", err)
+	}
+
+	// So do their Profilers.
+	r, _, p := E(sum.U("i").D("j"), v.U("j")).Eval()
+	r.Reify()
+	var nodes []string
+	for _, n := range p.Stats().Nodes {
+		nodes = append(nodes, n.Node)
+	}
+	wantNodes := []string{"plus", "^i_j*^j->^i"}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("Got nodes %v, want %v", nodes, wantNodes)
+	}
+
+	// An Evaluator that isn't a Shaper is evaluated once,
+	// however deep it is nested.
+	c := &counter{t: *v}
+	if _, err, _ := E(Expression{e: c}.U("j"), a.U("i").D("j")).Eval(); err != nil || c.n != 1 {
+		t.Errorf("Got %v, and %v evaluations of a nested counter, want 1", err, c.n)
+	}
+	m := &counter{t: *a}
+	counted := []struct {
+		description string
+		e           Evaluator
+	}{
+		{"Plus.", Plus{A: m, B: *a}},
+		{"Sum.", Sum{*a, Negated{E: m}}},
+		{"Plus in a Term.", E(Plus{A: m, B: *a}.U("i").D("j"), v.U("j"))},
+		{"Term in a Sum.", Sum{E(Expression{e: m}.U("i").D("j"), v.U("j")), E(a.U("i").D("j"), v.U("j"))}},
+	}
+	for _, tc := range counted {
+		m.n = 0
+		if _, err, _ := tc.e.Eval(); err != nil || m.n != 1 {
+			t.Errorf("On %v: got %v, and %v evaluations of the counter, want 1", tc.description, err, m.n)
+		}
+	}
+}
+
+// counter is an Evaluator that counts its evaluations.
+type counter struct {
+	t Tensor
+	n int
+}
+
+func (c *counter) Eval() (Tensor, error, *Profiler) {
+	c.n++
+	return c.t, nil, nil
+}
+
+func TestShape(t *testing.T) {
+	a := newMatrix([][]int{{1, 2, 3}, {4, 5, 6}})
+	b := newMatrix([][]int{{1}, {2}, {3}})
+	v := newVec(1, 2, 3)
+	row := newRow(1, 2)
+	sum := Plus{E(a.U("i").D("j")), E(a.U("i").D("j"))}
+	testCases := []struct {
+		description string
+		e           Evaluator
+		signature   string
+		dimension   []int
+		free        []string
+	}{
+		{"Tensor.", *a, "ud", []int{2, 3}, nil},
+		{"Product.", E(a.U("i").D("j"), b.U("j").D("k")), "ud", []int{2, 1}, []string{"i", "k"}},
+		{"Transposed product.", E(a.U("i").D("j"), b.U("j").D("k")).To("ki"), "du", []int{1, 2}, []string{"k", "i"}},
+		{"Contraction to a scalar.", E(row.D("i"), a.U("i").D("j"), v.U("j")), "", []int{}, []string{}},
+		{"Trace.", E(newMatrix([][]int{{1, 2}, {3, 4}}).U("i").D("i")), "", []int{}, []string{}},
+		{"Generalized.", E(v.U("i"), v.U("i")).Generalized().To("i"), "u", []int{3}, []string{"i"}},
+		{"Sum.", sum, "ud", []int{2, 3}, []string{"i", "j"}},
+		{"Nested sum.", E(sum.U("x").D("y"), v.U("y")), "u", []int{2}, []string{"x"}},
+		{"Linear combination.", Minus(sum, Scaled{2, E(a.U("i").D("j"))}), "ud", []int{2, 3}, []string{"i", "j"}},
+		{"Application.", Apply{NewStringFunction(func(s string) string { return s }),
+			E(newStringMatrix([][]string{{"a"}}).U("i").D("j"))},
+			"ud", []int{1, 1}, []string{"i", "j"}},
+	}
+	for _, tc := range testCases {
+		s, err := ShapeOf(tc.e)
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tc.description, err)
+			continue
+		}
+		if s.Signature() != tc.signature || !reflect.DeepEqual(s.Dimension(), tc.dimension) ||
+			!reflect.DeepEqual(s.FreeIndices(), tc.free) {
+			t.Errorf("On %v: got %q %v %v, want %q %v %v", tc.description,
+				s.Signature(), s.Dimension(), s.FreeIndices(), tc.signature, tc.dimension, tc.free)
+		}
+		// Eval agrees.
+		r, err, _ := tc.e.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error evaluating %v", tc.description, err)
+			continue
+		}
+		if r.Signature() != s.Signature() || !reflect.DeepEqual(r.Dimension(), s.Dimension()) {
+			t.Errorf("On %v: evaluated to %q %v, but the shape is %q %v", tc.description,
+				r.Signature(), r.Dimension(), s.Signature(), s.Dimension())
+		}
+	}
+
+	errorCases := []struct {
+		description string
+		e           Evaluator
+		want        error
+	}{
+		{"Contracted dimensions differ.", E(a.U("i").D("j"), a.U("j").D("k")), ErrDimensionMismatch{}},
+		{"Too few indices.", E(a.U("i")), ErrSignatureMismatch{}},
+		{"Nested.", E(E(a.U("i").D("j"), a.U("j").D("k")).U("x").D("y")), ErrDimensionMismatch{}},
+		{"Sum of different indices.", Plus{E(a.U("i").D("j")), E(b.U("i").D("k"))}, ErrIndexMismatch{}},
+		{"Sum of different types.", Plus{*a, newStringMatrix([][]string{{"a", "b", "c"}, {"d", "e", "f"}})},
+			ErrTypeMismatch{}},
+		{"Function of a different type.", Apply{NewRealScalar(2), E(a.U("i").D("j"))}, ErrTypeMismatch{}},
+	}
+	for _, tc := range errorCases {
+		_, err := ShapeOf(tc.e)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("On %v: got %v, want a %T", tc.description, err, tc.want)
+		}
+	}
+}
+
+func TestRing(t *testing.T) {
+	m := newMatrix([][]int{{1, 2}, {3, 4}})
+	empty := Zeros(IntType, "u", []int{0})
+	emptyRow := Zeros(IntType, "d", []int{0})
+	identity := Identity(IntType, "ud", []int{2, 2})
+	emptyMatrix := Zeros(IntType, "ud", []int{0, 0})
+	delta := Identity(IntType, "udd", []int{2, 2, 2})
+	testCases := []struct {
+		description string
+		e           Evaluator
+		want        Tensor
+	}{
+		{"Contraction of an empty index.", E(emptyRow.D("i"), empty.U("i")), newScalar(0)},
+		{"Trace of an empty matrix.", E(emptyMatrix.U("i").D("i")), newScalar(0)},
+		{"Identity on the left.", E(identity.U("i").D("j"), m.U("j").D("k")), *m},
+		{"Identity on the right.", E(m.U("i").D("j"), identity.U("j").D("k")), *m},
+		{"Plus zero.", Plus{E(m.U("i").D("j")), Zeros(IntType, "ud", []int{2, 2})}, *m},
+		{"Delta of rank three.", E(delta.U("i").D("jk"), newVec(1, 1).U("j")),
+			identity},
+		{"Real identity.", Identity(RealType, "ud", []int{2, 2}),
+			NewRealTensor(func(i ...int) float64 { return float64(1 - (i[0]-i[1])*(i[0]-i[1])) }, "ud", []int{2, 2})},
+		{"String zeros.", Zeros(StringType, "u", []int{2}), NewStringTensor(func(i ...int) string { return "0" }, "u", []int{2})},
+	}
+	for _, tc := range testCases {
+		r, err, _ := tc.e.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tc.description, err)
+			continue
+		}
+		if !r.Equal(tc.want) {
+			t.Errorf("On %v: got %v, want %v", tc.description, r, tc.want)
+		}
+	}
+
+	// Equal looks at every element, and at the shape.
+	if m.Equal(identity) {
+		t.Errorf("%v equals %v", m, identity)
+	}
+	if identity.Equal(Identity(IntType, "uu", []int{2, 2})) {
+		t.Errorf("Tensors of different signatures are equal")
+	}
+	tr, err := Trace(emptyMatrix, 0, 1, nil)
+	if err != nil || !tr.Equal(newScalar(0)) {
+		t.Errorf("Trace of an empty matrix is %v, %v", tr, err)
+	}
+}
+
+// mod7 is the integers mod 7, a Type defined outside of types.go.
+type mod7 struct{}
+
+func (mod7) Multiply(x, y interface{}) interface{} { return x.(int) * y.(int) % 7 }
+func (mod7) Add(x, y interface{}) interface{}      { return (x.(int) + y.(int)) % 7 }
+func (mod7) Negate(x interface{}) interface{}      { return (7 - x.(int)) % 7 }
+func (mod7) Zero() interface{}                     { return 0 }
+func (mod7) One() interface{}                      { return 1 }
+func (mod7) Equal(x, y interface{}) bool           { return x.(int) == y.(int) }
+
+// lopsided adds by taking the first element, which isn't commutative.
+type lopsided struct{ IntRing }
+
+func (lopsided) Add(x, y int) int { return x }
+
+func TestCustomType(t *testing.T) {
+	m := NewTensor(mod7{}, func(i ...int) interface{} { return 3*i[0] + i[1] }, "ud", []int{2, 2})
+	v := NewDenseTensor(mod7{}, []interface{}{5, 6}, nil, "u", []int{2})
+	square := NewFunction(mod7{}, func(x interface{}) interface{} { return x.(int) * x.(int) % 7 })
+	testCases := []struct {
+		description string
+		e           Evaluator
+		reified     [][]interface{}
+	}{
+		{"Mat multiply.", E(m.U("i").D("j"), v.U("j")), [][]interface{}{{6}, {4}}},
+		{"Application.", Apply{Func: square, E: E(m.U("i").D("j"), v.U("j"))}, [][]interface{}{{1}, {2}}},
+		{"Trace.", E(m.U("i").D("i")), [][]interface{}{{4}}},
+		{"Minus.", Minus(m, m), [][]interface{}{{0, 0}, {0, 0}}},
+	}
+	for _, tc := range testCases {
+		r, err, _ := tc.e.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tc.description, err)
+			continue
+		}
+		if !reflect.DeepEqual(r.Reify(), tc.reified) {
+			t.Errorf("On %v: got %v, want %v", tc.description, r.Reify(), tc.reified)
+		}
+	}
+	if _, err, _ := (Plus{A: m, B: *newMatrix([][]int{{1, 2}, {3, 4}})}).Eval(); !errors.Is(err, ErrTypeMismatch{}) {
+		t.Errorf("Got %v adding mod 7 and int Tensors, want an ErrTypeMismatch", err)
+	}
+
+	small := func(r *rand.Rand) int { return r.Intn(21) - 10 }
+	ringCases := []struct {
+		description string
+		check       error
+		law         string
+	}{
+		{"Mod 7.", CheckRing(Type(mod7{}), func(r *rand.Rand) interface{} { return r.Intn(7) }, 100), ""},
+		{"Integers.", CheckRing(Ring[int](IntRing{}), small, 100), ""},
+		{"Reals.", CheckRing(Ring[float64](RealRing{}), func(r *rand.Rand) float64 { return float64(small(r)) }, 100), ""},
+		{"Boxed integers.", CheckRing(IntType, func(r *rand.Rand) interface{} { return small(r) }, 100), ""},
+		{"Lopsided.", CheckRing(Ring[int](lopsided{}), small, 100), "commutativity of Add"},
+		{"Strings are formal sums, not a ring.",
+			CheckRing(Ring[string](StringRing{}), func(r *rand.Rand) string { return fmt.Sprint(small(r)) }, 100),
+			"commutativity of Add"},
+	}
+	for _, tc := range ringCases {
+		var law ErrRingLaw
+		errors.As(tc.check, &law)
+		if law.Law != tc.law {
+			t.Errorf("On %v: got %v, want law %q broken", tc.description, tc.check, tc.law)
+		}
+	}
+}
+
+// rationals parses strings into a dense rational tensor.
+func rationals(signature string, dim []int, s ...string) Tensor {
+	data := make([]*big.Rat, len(s))
+	for i, x := range s {
+		r, err := RationalRing{}.Parse(x)
+		if err != nil {
+			panic(err)
+		}
+		data[i] = r
+	}
+	return NewDenseRationalTensor(data, nil, signature, dim)
+}
+
+func TestRational(t *testing.T) {
+	// A Hilbert matrix, built in a buffer reused for every element.
+	buf := new(big.Rat)
+	hilbert := NewRationalTensor(func(i ...int) *big.Rat {
+		return buf.SetFrac64(1, int64(i[0]+i[1]+1))
+	}, "ud", []int{2, 2})
+	ones := rationals("u", []int{2}, "1", "1")
+	cube := NewRationalFunction(func(x *big.Rat) *big.Rat {
+		return x.Mul(x, new(big.Rat).Mul(x, x))
+	})
+	testCases := []struct {
+		description string
+		e           Evaluator
+		want        Tensor
+	}{
+		{"Mat multiply.", E(hilbert.U("i").D("j"), ones.U("j")), rationals("u", []int{2}, "3/2", "5/6")},
+		{"Square.", E(hilbert.U("i").D("j"), hilbert.U("j").D("k")),
+			rationals("ud", []int{2, 2}, "5/4", "2/3", "2/3", "13/36")},
+		{"Scaled trace.", Scaled{C: big.NewRat(1, 2), E: E(hilbert.U("i").D("i"))}, rationals("", []int{}, "2/3")},
+		{"Difference.", Minus(hilbert, hilbert), Zeros(RationalType, "ud", []int{2, 2})},
+		{"Application.", Apply{Func: cube, E: ones}, ones},
+		{"Decimals.", rationals("u", []int{2}, "0.25", "-1e-1"), rationals("u", []int{2}, "1/4", "-1/10")},
+	}
+	for _, tc := range testCases {
+		r, err, _ := tc.e.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tc.description, err)
+			continue
+		}
+		// Read every element twice, in case the first read changed any.
+		for k := 0; k < 2; k++ {
+			if !r.Equal(tc.want) {
+				t.Errorf("On %v: got %v, want %v", tc.description, r, tc.want)
+			}
+		}
+	}
+
+	// Tensor.String writes fractions as Parse reads them.
+	product, _, _ := E(hilbert.U("i").D("j"), ones.U("j")).Eval()
+	if s := product.String(); !strings.Contains(s, "[3/2]") || !strings.Contains(s, "[5/6]") {
+		t.Errorf("Printed %v", s)
+	}
+	if _, err := (RationalRing{}).Parse("1/x"); err == nil {
+		t.Errorf("Parsed 1/x")
+	}
+}
+
+func TestBigInt(t *testing.T) {
+	// 2^40 everywhere, so that contracting two of them wraps an int64.
+	big40 := NewDenseIntTensor([]int{1 << 40, 1 << 40}, nil, "u", []int{2})
+	b40, err := BigInts(big40)
+	if err != nil {
+		t.Fatalf("Converting to big ints: %v", err)
+	}
+	buf := new(big.Int)
+	counter := NewBigIntTensor(func(i ...int) *big.Int {
+		return buf.SetInt64(int64(3*i[0] + i[1]))
+	}, "ud", []int{2, 2})
+	double := NewBigIntFunction(func(x *big.Int) *big.Int {
+		return x.Lsh(x, 1)
+	})
+	testCases := []struct {
+		description string
+		e           Evaluator
+		want        Tensor
+	}{
+		{"Past int64.", E(b40.U("i"), b40.D("i")).Permissive(), bigInts("", []int{}, "2417851639229258349412352")},
+		{"Mat multiply.", E(counter.U("i").D("j"), b40.U("j")),
+			bigInts("u", []int{2}, "1099511627776", "7696581394432")},
+		{"Application.", Apply{Func: double, E: counter}, bigInts("ud", []int{2, 2}, "0", "2", "6", "8")},
+		{"Negated.", Negated{E: b40}, bigInts("u", []int{2}, "-1099511627776", "-1099511627776")},
+	}
+	for _, tc := range testCases {
+		r, err, _ := tc.e.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tc.description, err)
+			continue
+		}
+		for k := 0; k < 2; k++ {
+			if !r.Equal(tc.want) {
+				t.Errorf("On %v: got %v, want %v", tc.description, r, tc.want)
+			}
+		}
+	}
+
+	// Back to ints, if they fit.
+	small, err := Ints(bigInts("u", []int{2}, "-7", "9"))
+	if err != nil || !reflect.DeepEqual(small.Reify(), [][]interface{}{{-7}, {9}}) {
+		t.Errorf("Got %v, %v converting back to ints", small, err)
+	}
+	square, _, _ := E(b40.U("i"), b40.D("i")).Permissive().Eval()
+	if _, err := Ints(square); !errors.Is(err, ErrOverflow{}) {
+		t.Errorf("Got %v converting 2^81 to an int, want an ErrOverflow", err)
+	}
+	if _, err := BigInts(bigInts("u", []int{1}, "1")); !errors.Is(err, ErrTypeMismatch{}) {
+		t.Errorf("Got %v converting big ints to big ints, want an ErrTypeMismatch", err)
+	}
+
+	small64 := func(r *rand.Rand) int64 { return int64(r.Intn(21) - 10) }
+	if err := CheckRing(Ring[*big.Int](BigIntRing{}), func(r *rand.Rand) *big.Int { return big.NewInt(small64(r)) }, 100); err != nil {
+		t.Errorf("Big ints: %v", err)
+	}
+	if err := CheckRing(Ring[int64](Int64Ring{}), small64, 100); err != nil {
+		t.Errorf("Int64s: %v", err)
+	}
+}
+
+func TestInt64(t *testing.T) {
+	v := NewDenseInt64Tensor([]int64{1 << 40, 1 << 40}, nil, "u", []int{2})
+	w := NewDenseInt64Tensor([]int64{3, -4}, nil, "u", []int{2})
+	min := NewDenseInt64Tensor([]int64{math.MinInt64}, nil, "u", []int{1})
+	max := NewDenseInt64Tensor([]int64{math.MaxInt64}, nil, "u", []int{1})
+	one := NewDenseInt64Tensor([]int64{1}, nil, "u", []int{1})
+	testCases := []struct {
+		description string
+		e           Evaluator
+		reified     [][]interface{}
+		op          string
+	}{
+		{"Fits.", E(w.U("i"), w.D("i")).Permissive(), [][]interface{}{{int64(25)}}, ""},
+		{"Multiply.", E(v.U("i"), v.D("i")).Permissive(), nil, "multiply"},
+		{"Add.", Plus{A: max, B: one}, nil, "add"},
+		{"Negate.", Negated{E: min}, nil, "negate"},
+		{"Scale.", Scaled{C: int64(2), E: max}, nil, "multiply"},
+		{"Nested.", E(E(v.U("i"), v.U("j")).U("ij"), w.D("i"), w.D("j")).Permissive(), nil, "multiply"},
+		{"Parallel.", E(v.U("i"), v.U("j")).Parallel(2), nil, "multiply"},
+	}
+	for _, tc := range testCases {
+		r, err, _ := tc.e.Eval()
+		if tc.op == "" {
+			if err != nil || !reflect.DeepEqual(r.Reify(), tc.reified) {
+				t.Errorf("On %v: got %v, %v, want %v", tc.description, r, err, tc.reified)
+			}
+			continue
+		}
+		var overflow ErrOverflow
+		if !errors.As(err, &overflow) || overflow.Op != tc.op {
+			t.Errorf("On %v: got %v, want an ErrOverflow on %v", tc.description, err, tc.op)
+		}
+	}
+
+	// Int64 Tensors convert to big ints, where they don't overflow.
+	b, err := BigInts(v)
+	if err != nil {
+		t.Fatalf("Converting to big ints: %v", err)
+	}
+	if r, err, _ := E(b.U("i"), b.D("i")).Permissive().Eval(); err != nil || !r.Equal(bigInts("", []int{}, "2417851639229258349412352")) {
+		t.Errorf("Got %v, %v squaring as big ints", r, err)
+	}
+}
+
+// bigInts parses strings into a dense big int tensor.
+func bigInts(signature string, dim []int, s ...string) Tensor {
+	data := make([]*big.Int, len(s))
+	for i, x := range s {
+		b, err := BigIntRing{}.Parse(x)
+		if err != nil {
+			panic(err)
+		}
+		data[i] = b
+	}
+	return NewDenseBigIntTensor(data, nil, signature, dim)
+}
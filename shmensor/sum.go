@@ -0,0 +1,256 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shmensor
+
+import (
+	"fmt"
+	"time"
+)
+
+// Sums line their terms up by index label, not by position, so
+//
+//	Plus{E(a.U("i").D("j")), E(b.D("j").U("i"))}
+//
+// adds a^i_j to b_j^i, whatever order the slots of b come in.
+// Every term must have the same free indices as the first, each
+// with the same variance and dimension. Terms without labels,
+// like a bare Tensor, are added slot by slot as they always were.
+
+// SumOf adds up any number of Evaluators, like Plus does two.
+// Give a term a coefficient with Scaled, or subtract it with
+// Negated, to make any linear combination. A Sum with no terms
+// returns an ErrEmptySum.
+//
+// a^i_j + 2 b^i_j - c_j^i example
+// Sum{E(a.U("i").D("j")), Scaled{2, E(b.U("i").D("j"))}, Negated{E(c.D("j").U("i"))}}
+type SumOf[T any] []EvaluatorOf[T]
+
+type Sum = SumOf[interface{}]
+
+func (s SumOf[T]) Eval() (TensorOf[T], error, *Profiler) {
+	return checked(func() (TensorOf[T], error, *Profiler) {
+		return sum("sum", s)
+	})
+}
+
+// Minus returns the Sum a - b.
+func Minus[T any](a, b EvaluatorOf[T]) SumOf[T] {
+	return SumOf[T]{a, NegatedOf[T]{b}}
+}
+
+// ScaledOf multiplies every element of E by C, which must be an
+// element of the same type, like 2 for an int Tensor or 0.5 for
+// a real one. Shape and Eval return an ErrTypeMismatch if it isn't.
+type ScaledOf[T any] struct {
+	C T
+	E EvaluatorOf[T]
+}
+
+type Scaled = ScaledOf[interface{}]
+
+func (s ScaledOf[T]) Eval() (TensorOf[T], error, *Profiler) {
+	return checked(func() (TensorOf[T], error, *Profiler) {
+		t, err, p := scale("scale", s.E, func(t Ring[T], x T) T {
+			return t.Multiply(s.C, x)
+		})
+		if err == nil && !holds(t.t, s.C) {
+			return TensorOf[T]{}, ErrTypeMismatch{Op: "scale", A: s.C, B: t.t}, p
+		}
+		return t, err, p
+	})
+}
+
+// holds reports whether x can be an element of the Ring r. Only
+// the package Types can tell; any other Ring is taken at its word.
+func holds(r interface{}, x interface{}) bool {
+	if h, ok := r.(interface{ holds(interface{}) bool }); ok {
+		return h.holds(x)
+	}
+	return true
+}
+
+// NegatedOf is E with every element negated.
+type NegatedOf[T any] struct {
+	E EvaluatorOf[T]
+}
+
+type Negated = NegatedOf[interface{}]
+
+func (n NegatedOf[T]) Eval() (TensorOf[T], error, *Profiler) {
+	return checked(func() (TensorOf[T], error, *Profiler) {
+		return scale("negate", n.E, func(t Ring[T], x T) T {
+			return t.Negate(x)
+		})
+	})
+}
+
+// scale evaluates e and maps f over its elements.
+func scale[T any](op string, e EvaluatorOf[T], f func(Ring[T], T) T) (TensorOf[T], error, *Profiler) {
+	p := &Profiler{}
+	t, err, c := e.Eval()
+	p.adopt(c)
+	if err != nil {
+		return TensorOf[T]{}, fmt.Errorf("%v: %w", op, err), p
+	}
+	n := p.node(op, OpScale, size(t.dim))
+	g := func(inner ...int) T {
+		defer n.elapsed(time.Now())
+		n.calls.Add(1)
+		n.multiplies.Add(1)
+		i := make([]int, len(inner))
+		copy(i, inner)
+		return f(t.t, t.f(i...))
+	}
+	return TensorOf[T]{
+		f:         g,
+		signature: t.signature,
+		dim:       t.dim,
+		t:         t.t,
+	}, nil, p
+}
+
+// A labeler knows the labels of the free indices of what it
+// evaluates to, in the order of the slots. Evaluators that
+// aren't labelers, or return false, are added by position.
+type labeler interface {
+	labels() ([]string, bool)
+}
+
+func (term TermOf[T]) labels() ([]string, bool) {
+	if len(term.List) == 0 {
+		return nil, false
+	}
+	if term.ordered {
+		return term.output, true
+	}
+	count := make(map[string]int)
+	for _, e := range term.List {
+		for _, ch := range e.indices {
+			count[ch]++
+		}
+	}
+	ret := []string{}
+	for _, e := range term.List {
+		for _, ch := range e.indices {
+			if count[ch] == 1 {
+				ret = append(ret, ch)
+			}
+		}
+	}
+	return ret, true
+}
+
+func (ps PlusOf[T]) labels() ([]string, bool) {
+	return SumOf[T]{ps.A, ps.B}.labels()
+}
+
+func (s SumOf[T]) labels() ([]string, bool) {
+	for _, e := range s {
+		if ls, ok := labelsOf(e); ok {
+			return ls, true
+		}
+	}
+	return nil, false
+}
+
+func (as ApplyOf[T]) labels() ([]string, bool) {
+	return labelsOf(as.E)
+}
+
+func (s ScaledOf[T]) labels() ([]string, bool) {
+	return labelsOf(s.E)
+}
+
+func (n NegatedOf[T]) labels() ([]string, bool) {
+	return labelsOf(n.E)
+}
+
+func labelsOf(e interface{}) ([]string, bool) {
+	if l, ok := e.(labeler); ok {
+		return l.labels()
+	}
+	return nil, false
+}
+
+// asTerm makes an Expression on its own a Term. An Expression
+// evaluates to its Tensor as is, so go through a Term to trace
+// and permute it.
+func asTerm[T any](e EvaluatorOf[T]) EvaluatorOf[T] {
+	if x, ok := e.(ExpressionOf[T]); ok && len(x.indices) > 0 {
+		return TermOf[T]{List: []ExpressionOf[T]{x}}
+	}
+	return e
+}
+
+// sum evaluates and adds es, permuting each term so its labels
+// line up with those of the first term that has any.
+func sum[T any](op string, es []EvaluatorOf[T]) (TensorOf[T], error, *Profiler) {
+	p := &Profiler{}
+	// Terms that can only tell their Shape by evaluating are
+	// evaluated once, here, for both the check and the sum.
+	es = append([]EvaluatorOf[T]{}, es...)
+	for k, e := range es {
+		if !opaque(e) {
+			continue
+		}
+		t, err, c := e.Eval()
+		p.adopt(c)
+		if err != nil {
+			return TensorOf[T]{}, fmt.Errorf("%v: term %v: %w", op, k+1, err), p
+		}
+		es[k] = t
+	}
+	s, err := sumShape(op, es)
+	if err != nil {
+		return TensorOf[T]{}, err, p
+	}
+	want := s.FreeIndices()
+	ts := make([]TensorOf[T], len(es))
+	for k, e := range es {
+		e = asTerm(e)
+		t, err, c := e.Eval()
+		p.adopt(c)
+		if err != nil {
+			return TensorOf[T]{}, fmt.Errorf("%v: term %v: %w", op, k+1, err), p
+		}
+		if l, ok := labelsOf(e); ok && want != nil {
+			r, err := contract([]ExpressionOf[T]{{t: &t, indices: l, signature: t.signature}}, want, 0, p)
+			if err != nil {
+				return TensorOf[T]{}, err, p
+			}
+			t = *r.t
+		}
+		ts[k] = t
+	}
+
+	first := ts[0]
+	n := p.node(op, OpPlus, size(first.dim))
+	f := func(inner ...int) T {
+		defer n.elapsed(time.Now())
+		n.calls.Add(1)
+		n.adds.Add(int64(len(ts) - 1))
+		i := make([]int, len(inner))
+		copy(i, inner)
+		ret := ts[0].f(i...)
+		for _, t := range ts[1:] {
+			ret = first.t.Add(ret, t.f(i...))
+		}
+		return ret
+	}
+	return TensorOf[T]{
+		f:         f,
+		signature: first.signature,
+		dim:       first.dim,
+		t:         first.t,
+	}, nil, p
+}
@@ -0,0 +1,187 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package typed is shmensor with the element type in the types.
+// A Tensor[float64] holds float64s, unboxed, and a Term[float64]
+// only multiplies Tensor[float64]s, so mixing an int and a real
+// tensor fails to compile rather than panicking in Reify.
+//
+// Mat multiply example
+//
+//	m := typed.NewRealTensor(f, "ud", []int{2, 2})
+//	v := typed.NewRealTensor(g, "u", []int{2})
+//	mv, err, _ := typed.E(m.U("i").D("j"), v.U("j")).Eval()
+//
+// Every type here is the generic one of shmensor under a shorter
+// name, so they share their methods and documentation, and the
+// generic functions of shmensor, like Transpose, Minus and
+// ShapeOf, take them as they are. shmensor.Box and shmensor.Unbox
+// convert to and from the interface{} Tensors of the older API.
+//
+// The shorter names are generic type aliases, so this package
+// needs Go 1.24 or later.
+package typed
+
+import (
+	"math/big"
+
+	shmeh "github.com/sillsm/shmensor/shmensor"
+)
+
+type (
+	Ring[T any]       = shmeh.Ring[T]
+	Tensor[T any]     = shmeh.TensorOf[T]
+	Evaluator[T any]  = shmeh.EvaluatorOf[T]
+	Expression[T any] = shmeh.ExpressionOf[T]
+	Term[T any]       = shmeh.TermOf[T]
+	Plus[T any]       = shmeh.PlusOf[T]
+	Sum[T any]        = shmeh.SumOf[T]
+	Apply[T any]      = shmeh.ApplyOf[T]
+	Scaled[T any]     = shmeh.ScaledOf[T]
+	Negated[T any]    = shmeh.NegatedOf[T]
+	Function[T any]   = shmeh.FunctionOf[T]
+	Metric[T any]     = shmeh.MetricOf[T]
+	Shaper[T any]     = shmeh.ShaperOf[T]
+)
+
+// E wraps up a bunch of expressions into a term.
+func E[T any](i ...Expression[T]) Term[T] {
+	return Term[T]{List: i}
+}
+
+func NewIntTensor(f func(i ...int) int, signature string, dim []int) Tensor[int] {
+	return shmeh.NewTensorOf(shmeh.IntRing{}, f, signature, dim)
+}
+
+// NewDenseIntTensor is shmensor.NewDenseIntTensor without the boxing.
+func NewDenseIntTensor(data []int, strides []int, signature string, dim []int) Tensor[int] {
+	return shmeh.NewDenseTensorOf(shmeh.IntRing{}, data, strides, signature, dim)
+}
+
+func NewRealTensor(f func(i ...int) float64, signature string, dim []int) Tensor[float64] {
+	return shmeh.NewTensorOf(shmeh.RealRing{}, f, signature, dim)
+}
+
+// NewDenseRealTensor is shmensor.NewDenseRealTensor without the boxing.
+func NewDenseRealTensor(data []float64, strides []int, signature string, dim []int) Tensor[float64] {
+	return shmeh.NewDenseTensorOf(shmeh.RealRing{}, data, strides, signature, dim)
+}
+
+func NewRealFunction(f func(r float64) float64) Function[float64] {
+	return shmeh.NewFunctionOf(shmeh.RealRing{}, f)
+}
+
+func NewRealScalar(f float64) Function[float64] {
+	return NewRealFunction(func(x float64) float64 {
+		return f * x
+	})
+}
+
+func NewComplexTensor(f func(i ...int) complex128, signature string, dim []int) Tensor[complex128] {
+	return shmeh.NewTensorOf(shmeh.ComplexRing{}, f, signature, dim)
+}
+
+// NewDenseComplexTensor is shmensor.NewDenseComplexTensor without the boxing.
+func NewDenseComplexTensor(data []complex128, strides []int, signature string, dim []int) Tensor[complex128] {
+	return shmeh.NewDenseTensorOf(shmeh.ComplexRing{}, data, strides, signature, dim)
+}
+
+func NewStringTensor(f func(i ...int) string, signature string, dim []int) Tensor[string] {
+	return shmeh.NewTensorOf(shmeh.StringRing{}, f, signature, dim)
+}
+
+// NewDenseStringTensor is shmensor.NewDenseStringTensor without the boxing.
+func NewDenseStringTensor(data []string, strides []int, signature string, dim []int) Tensor[string] {
+	return shmeh.NewDenseTensorOf(shmeh.StringRing{}, data, strides, signature, dim)
+}
+
+func NewStringFunction(f func(s string) string) Function[string] {
+	return shmeh.NewFunctionOf(shmeh.StringRing{}, f)
+}
+
+// NewRationalTensor is shmensor.NewRationalTensor, copying
+// what f returns in the same way.
+func NewRationalTensor(f func(i ...int) *big.Rat, signature string, dim []int) Tensor[*big.Rat] {
+	return shmeh.NewTensorOf(shmeh.RationalRing{}, func(i ...int) *big.Rat {
+		return new(big.Rat).Set(f(i...))
+	}, signature, dim)
+}
+
+// NewDenseRationalTensor is shmensor.NewDenseRationalTensor without the boxing.
+func NewDenseRationalTensor(data []*big.Rat, strides []int, signature string, dim []int) Tensor[*big.Rat] {
+	copied := make([]*big.Rat, len(data))
+	for i, x := range data {
+		copied[i] = new(big.Rat).Set(x)
+	}
+	return shmeh.NewDenseTensorOf(shmeh.RationalRing{}, copied, strides, signature, dim)
+}
+
+// NewRationalFunction is shmensor.NewRationalFunction, handing
+// f a copy of each element in the same way.
+func NewRationalFunction(f func(r *big.Rat) *big.Rat) Function[*big.Rat] {
+	return shmeh.NewFunctionOf(shmeh.RationalRing{}, func(x *big.Rat) *big.Rat {
+		return f(new(big.Rat).Set(x))
+	})
+}
+
+// NewBigIntTensor is shmensor.NewBigIntTensor, copying
+// what f returns in the same way.
+func NewBigIntTensor(f func(i ...int) *big.Int, signature string, dim []int) Tensor[*big.Int] {
+	return shmeh.NewTensorOf(shmeh.BigIntRing{}, func(i ...int) *big.Int {
+		return new(big.Int).Set(f(i...))
+	}, signature, dim)
+}
+
+// NewDenseBigIntTensor is shmensor.NewDenseBigIntTensor without the boxing.
+func NewDenseBigIntTensor(data []*big.Int, strides []int, signature string, dim []int) Tensor[*big.Int] {
+	copied := make([]*big.Int, len(data))
+	for i, x := range data {
+		copied[i] = new(big.Int).Set(x)
+	}
+	return shmeh.NewDenseTensorOf(shmeh.BigIntRing{}, copied, strides, signature, dim)
+}
+
+// NewBigIntFunction is shmensor.NewBigIntFunction, handing
+// f a copy of each element in the same way.
+func NewBigIntFunction(f func(r *big.Int) *big.Int) Function[*big.Int] {
+	return shmeh.NewFunctionOf(shmeh.BigIntRing{}, func(x *big.Int) *big.Int {
+		return f(new(big.Int).Set(x))
+	})
+}
+
+// BigInts is shmensor.BigInts for int Tensors.
+func BigInts(t Tensor[int]) (Tensor[*big.Int], error) {
+	b, err := shmeh.BigInts(shmeh.Box(t))
+	if err != nil {
+		return Tensor[*big.Int]{}, err
+	}
+	return shmeh.Unbox[*big.Int](b)
+}
+
+// Ints is shmensor.Ints without the boxing.
+func Ints(t Tensor[*big.Int]) (Tensor[int], error) {
+	i, err := shmeh.Ints(shmeh.Box(t))
+	if err != nil {
+		return Tensor[int]{}, err
+	}
+	return shmeh.Unbox[int](i)
+}
+
+func NewInt64Tensor(f func(i ...int) int64, signature string, dim []int) Tensor[int64] {
+	return shmeh.NewTensorOf(shmeh.Int64Ring{}, f, signature, dim)
+}
+
+// NewDenseInt64Tensor is shmensor.NewDenseInt64Tensor without the boxing.
+func NewDenseInt64Tensor(data []int64, strides []int, signature string, dim []int) Tensor[int64] {
+	return shmeh.NewDenseTensorOf(shmeh.Int64Ring{}, data, strides, signature, dim)
+}
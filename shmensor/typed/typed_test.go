@@ -0,0 +1,116 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package typed
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	shmeh "github.com/sillsm/shmensor/shmensor"
+)
+
+func newMatrix(m [][]float64) *Tensor[float64] {
+	t := NewRealTensor(
+		func(j ...int) float64 {
+			return m[j[0]][j[1]]
+		},
+		"ud",
+		[]int{len(m), len(m[0])})
+	return &t
+}
+
+func newVec(v ...float64) *Tensor[float64] {
+	t := NewDenseRealTensor(v, nil, "u", []int{len(v)})
+	return &t
+}
+
+func TestTyped(t *testing.T) {
+	m := newMatrix([][]float64{{1, 2}, {3, 4}})
+	v := newVec(1, 2)
+	double := NewRealScalar(2)
+	testCases := []struct {
+		description string
+		e           Evaluator[float64]
+		reified     [][]float64
+	}{
+		{"Mat multiply.", E(m.U("i").D("j"), v.U("j")), [][]float64{{5}, {11}}},
+		{"Trace.", E(m.U("i").D("i")), [][]float64{{5}}},
+		{"Hadamard product.", E(v.U("i"), v.U("i")).Generalized().To("i"), [][]float64{{1}, {4}}},
+		{"Sum by label.", Plus[float64]{A: E(m.U("i").D("j")), B: E(m.D("j").U("i").I())},
+			[][]float64{{2, 4}, {6, 8}}},
+		{"Application.", Apply[float64]{Func: double, E: E(m.U("i").D("j"), v.U("j"))}, [][]float64{{10}, {22}}},
+		{"Linear combination.", shmeh.Minus(*m, Scaled[float64]{C: 3, E: *m}),
+			[][]float64{{-2, -4}, {-6, -8}}},
+	}
+	for _, tc := range testCases {
+		r, err, _ := tc.e.Eval()
+		if err != nil {
+			t.Errorf("On %v: unexpected error %v", tc.description, err)
+			continue
+		}
+		if got := r.Reify(); !reflect.DeepEqual(got, tc.reified) {
+			t.Errorf("On %v: got %v, want %v", tc.description, got, tc.reified)
+		}
+	}
+}
+
+func TestBox(t *testing.T) {
+	m := newMatrix([][]float64{{1, 2}, {3, 4}})
+	// A boxed Tensor mixes with those of the package constructors.
+	old := shmeh.NewRealTensor(func(i ...int) float64 { return 1 }, "ud", []int{2, 2})
+	sum, err, _ := shmeh.Plus{A: shmeh.Box(*m), B: old}.Eval()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	want := [][]interface{}{{2., 3.}, {4., 5.}}
+	if got := sum.Reify(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+
+	back, err := shmeh.Unbox[float64](sum)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if got := back.Reify(); !reflect.DeepEqual(got, [][]float64{{2, 3}, {4, 5}}) {
+		t.Errorf("Unboxed to %v", got)
+	}
+	if _, err := shmeh.Unbox[int](sum); !errors.Is(err, shmeh.ErrTypeMismatch{}) {
+		t.Errorf("Got %v, want an ErrTypeMismatch", err)
+	}
+}
+
+func TestBigInts(t *testing.T) {
+	v := NewDenseIntTensor([]int{1 << 40, -3}, nil, "u", []int{2})
+	b, err := BigInts(v)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	square, err, _ := E(b.U("i"), b.U("i")).Generalized().To("i").Eval()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if got := square.Reify()[0][0].String(); got != "1208925819614629174706176" {
+		t.Errorf("Got %v squaring 2^40", got)
+	}
+	if _, err := Ints(square); !errors.Is(err, shmeh.ErrOverflow{}) {
+		t.Errorf("Got %v, want an ErrOverflow", err)
+	}
+	back, err := Ints(b)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if got := back.Reify(); !reflect.DeepEqual(got, [][]int{{1 << 40}, {-3}}) {
+		t.Errorf("Converted back to %v", got)
+	}
+}
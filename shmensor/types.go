@@ -17,46 +17,239 @@ import (
 )
 
 // This file is used to define some package-default Tensor types.
-// Interface may become open in the future so clients can define their own.
-// For example, Tensor spaces over finite fields, or modules over arbitrary rings.
+// Clients can define their own too, like Tensor spaces over finite
+// fields, or modules over arbitrary rings: implement Type, check it
+// with CheckRing, and build Tensors and Functions over it with
+// NewTensor and NewFunction. A Ring[T] goes with NewTensorOf and
+// NewFunctionOf the same way.
+//
+// Each default is a Ring over its Go type, for TensorOfs of that
+// type, and a Type over interface{} through boxed, for Tensors.
+
+// NewTensor makes a lazy Tensor of elements of the Type t with
+// coordinate function f, which must return elements t can take.
+//
+// Integers mod 7 example
+// NewTensor(mod7{}, func(i ...int) interface{} { return i[0] % 7 }, "u", []int{3})
+func NewTensor(t Type, f func(i ...int) interface{}, signature string, dim []int) Tensor {
+	return NewTensorOf(t, f, signature, dim)
+}
+
+// NewDenseTensor lays a Tensor of elements of the Type t over a
+// flat slice, like NewDenseTensorOf.
+func NewDenseTensor(t Type, data []interface{}, strides []int, signature string, dim []int) Tensor {
+	return newDense(data, strides, signature, dim, t)
+}
+
+// NewFunction makes a Function on elements of the Type t.
+func NewFunction(t Type, f func(interface{}) interface{}) Function {
+	return NewFunctionOf(t, f)
+}
+
+// NewTensorOf makes a lazy TensorOf elements of the Ring r
+// with coordinate function f.
+func NewTensorOf[T any](r Ring[T], f func(i ...int) T, signature string, dim []int) TensorOf[T] {
+	return TensorOf[T]{
+		f:         f,
+		signature: signature,
+		dim:       dim,
+		t:         r,
+	}
+}
+
+// NewDenseTensorOf lays a TensorOf elements of the Ring r over a
+// flat slice. Element (i, j, ...) is data[i*strides[0] + j*strides[1]
+// + ...]; nil strides mean row major, with the last index varying
+// fastest.
+//
+// Like the other dense constructors, it panics unless there is
+// one stride and one letter of signature per dimension, every
+// stride is at least zero, and data holds every element.
+func NewDenseTensorOf[T any](r Ring[T], data []T, strides []int, signature string, dim []int) TensorOf[T] {
+	return newDense(data, strides, signature, dim, r)
+}
+
+// NewFunctionOf makes a FunctionOf elements of the Ring r.
+func NewFunctionOf[T any](r Ring[T], f func(T) T) FunctionOf[T] {
+	return FunctionOf[T]{f, r}
+}
+
+// boxed is the Type of a Ring[T] over interface{}. It
+// panics if handed an element that isn't a T.
+type boxed[T any] struct {
+	r Ring[T]
+}
+
+func (b boxed[T]) Multiply(x, y interface{}) interface{} {
+	return b.r.Multiply(x.(T), y.(T))
+}
+
+func (b boxed[T]) Add(x, y interface{}) interface{} {
+	return b.r.Add(x.(T), y.(T))
+}
+
+func (b boxed[T]) Negate(x interface{}) interface{} {
+	return b.r.Negate(x.(T))
+}
+
+func (b boxed[T]) Zero() interface{} {
+	return b.r.Zero()
+}
+
+func (b boxed[T]) One() interface{} {
+	return b.r.One()
+}
+
+func (b boxed[T]) Equal(x, y interface{}) bool {
+	return b.r.Equal(x.(T), y.(T))
+}
+
+func (b boxed[T]) holds(x interface{}) bool {
+	_, ok := x.(T)
+	return ok
+}
+
+func (b boxed[T]) inner() interface{} {
+	return b.r
+}
+
+// Print writes x out the way the Ring does, if it is a Printer,
+// or the way fmt does.
+func (b boxed[T]) Print(x interface{}) string {
+	if p, ok := b.r.(Printer[T]); ok {
+		return p.Print(x.(T))
+	}
+	return fmt.Sprint(x)
+}
+
+// Box returns t as a Tensor, for code written against
+// interface{} elements. Boxing a TensorOf one of the package
+// Rings gives the Tensor the package constructor would have made.
+func Box[T any](t TensorOf[T]) Tensor {
+	if b, ok := interface{}(t).(Tensor); ok {
+		return b
+	}
+	f := t.f
+	ret := Tensor{
+		f:         func(i ...int) interface{} { return f(i...) },
+		signature: t.signature,
+		dim:       t.dim,
+		workers:   t.workers,
+	}
+	if t.t != nil {
+		ret.t = boxed[T]{t.t}
+	}
+	return ret
+}
+
+// Unbox returns t as a TensorOf[T]. It returns an
+// ErrTypeMismatch unless t came from Box or a package
+// constructor for elements of type T.
+func Unbox[T any](t Tensor) (TensorOf[T], error) {
+	if u, ok := interface{}(t).(TensorOf[T]); ok {
+		return u, nil
+	}
+	b, ok := t.t.(boxed[T])
+	if !ok {
+		return TensorOf[T]{}, ErrTypeMismatch{Op: "unbox", A: boxed[T]{}, B: t.t}
+	}
+	f := t.f
+	return TensorOf[T]{
+		f:         func(i ...int) T { return f(i...).(T) },
+		signature: t.signature,
+		dim:       t.dim,
+		t:         b.r,
+		workers:   t.workers,
+	}, nil
+}
+
+// box copies data into a slice of interface{}.
+func box[T any](data []T) []interface{} {
+	ret := make([]interface{}, len(data))
+	for i, x := range data {
+		ret[i] = x
+	}
+	return ret
+}
+
 // Integers.
-type defaultInt struct{}
+type IntRing struct{}
+
+func (IntRing) Multiply(x, y int) int {
+	return x * y
+}
+
+func (IntRing) Add(x, y int) int {
+	return x + y
+}
+
+func (IntRing) Negate(x int) int {
+	return -x
+}
 
-func (dt defaultInt) Multiply(x, y interface{}) interface{} {
-	return x.(int) * y.(int)
+func (IntRing) Zero() int {
+	return 0
 }
 
-func (dt defaultInt) Add(x, y interface{}) interface{} {
-	return x.(int) + y.(int)
+func (IntRing) One() int {
+	return 1
 }
 
+func (IntRing) Equal(x, y int) bool {
+	return x == y
+}
+
+// IntType is IntRing as a Type, the Type of int Tensors.
+var IntType Type = boxed[int]{IntRing{}}
+
 func NewIntTensor(f func(i ...int) int, signature string, dim []int) Tensor {
-	return Tensor{
-		func(i ...int) interface{} { return f(i...) },
-		signature,
-		dim,
-		defaultInt{},
-	}
+	return Box(NewTensorOf(IntRing{}, f, signature, dim))
+}
+
+// NewDenseIntTensor lays an int tensor over a flat slice,
+// like NewDenseTensorOf.
+func NewDenseIntTensor(data []int, strides []int, signature string, dim []int) Tensor {
+	return newDense(box(data), strides, signature, dim, IntType)
 }
 
 // Reals.
-type defaultReal struct{}
+type RealRing struct{}
 
-func (dt defaultReal) Multiply(x, y interface{}) interface{} {
-	return x.(float64) * y.(float64)
+func (RealRing) Multiply(x, y float64) float64 {
+	return x * y
 }
 
-func (dt defaultReal) Add(x, y interface{}) interface{} {
-	return x.(float64) + y.(float64)
+func (RealRing) Add(x, y float64) float64 {
+	return x + y
 }
 
+func (RealRing) Negate(x float64) float64 {
+	return -x
+}
+
+func (RealRing) Zero() float64 {
+	return 0
+}
+
+func (RealRing) One() float64 {
+	return 1
+}
+
+func (RealRing) Equal(x, y float64) bool {
+	return x == y
+}
+
+// RealType is RealRing as a Type, the Type of real Tensors.
+var RealType Type = boxed[float64]{RealRing{}}
+
 func NewRealTensor(f func(i ...int) float64, signature string, dim []int) Tensor {
-	return Tensor{
-		func(i ...int) interface{} { return f(i...) },
-		signature,
-		dim,
-		defaultReal{},
-	}
+	return Box(NewTensorOf(RealRing{}, f, signature, dim))
+}
+
+// NewDenseRealTensor lays a real tensor over a flat slice,
+// like NewDenseTensorOf.
+func NewDenseRealTensor(data []float64, strides []int, signature string, dim []int) Tensor {
+	return newDense(box(data), strides, signature, dim, RealType)
 }
 
 func NewRealFunction(f func(r float64) float64) Function {
@@ -66,7 +259,7 @@ func NewRealFunction(f func(r float64) float64) Function {
 	}
 	return Function{
 		wrapper,
-		defaultReal{},
+		RealType,
 	}
 }
 
@@ -77,43 +270,83 @@ func NewRealScalar(f float64) Function {
 }
 
 // Complex numbers.
-type defaultComplex struct{}
+type ComplexRing struct{}
 
-func (dt defaultComplex) Multiply(x, y interface{}) interface{} {
-	return x.(complex128) * y.(complex128)
+func (ComplexRing) Multiply(x, y complex128) complex128 {
+	return x * y
 }
 
-func (dt defaultComplex) Add(x, y interface{}) interface{} {
-	return x.(complex128) + y.(complex128)
+func (ComplexRing) Add(x, y complex128) complex128 {
+	return x + y
 }
 
+func (ComplexRing) Negate(x complex128) complex128 {
+	return -x
+}
+
+func (ComplexRing) Zero() complex128 {
+	return 0
+}
+
+func (ComplexRing) One() complex128 {
+	return 1
+}
+
+func (ComplexRing) Equal(x, y complex128) bool {
+	return x == y
+}
+
+// ComplexType is ComplexRing as a Type, the Type of complex Tensors.
+var ComplexType Type = boxed[complex128]{ComplexRing{}}
+
 func NewComplexTensor(f func(i ...int) complex128, signature string, dim []int) Tensor {
-	return Tensor{
-		func(i ...int) interface{} { return f(i...) },
-		signature,
-		dim,
-		defaultComplex{},
-	}
+	return Box(NewTensorOf(ComplexRing{}, f, signature, dim))
+}
+
+// NewDenseComplexTensor lays a complex tensor over a flat slice,
+// like NewDenseTensorOf.
+func NewDenseComplexTensor(data []complex128, strides []int, signature string, dim []int) Tensor {
+	return newDense(box(data), strides, signature, dim, ComplexType)
 }
 
 // Strings
-type defaultString struct{}
+type StringRing struct{}
 
-func (ds defaultString) Multiply(x, y interface{}) interface{} {
-	return fmt.Sprintf("(%v)(%v)", x.(string), y.(string))
+func (StringRing) Multiply(x, y string) string {
+	return fmt.Sprintf("(%v)(%v)", x, y)
 }
 
-func (ds defaultString) Add(x, y interface{}) interface{} {
-	return fmt.Sprintf("%v + %v", x.(string), y.(string))
+func (StringRing) Add(x, y string) string {
+	return fmt.Sprintf("%v + %v", x, y)
 }
 
+func (StringRing) Negate(x string) string {
+	return fmt.Sprintf("-(%v)", x)
+}
+
+func (StringRing) Zero() string {
+	return "0"
+}
+
+func (StringRing) One() string {
+	return "1"
+}
+
+func (StringRing) Equal(x, y string) bool {
+	return x == y
+}
+
+// StringType is StringRing as a Type, the Type of string Tensors.
+var StringType Type = boxed[string]{StringRing{}}
+
 func NewStringTensor(f func(i ...int) string, signature string, dim []int) Tensor {
-	return Tensor{
-		func(i ...int) interface{} { return f(i...) },
-		signature,
-		dim,
-		defaultString{},
-	}
+	return Box(NewTensorOf(StringRing{}, f, signature, dim))
+}
+
+// NewDenseStringTensor lays a string tensor over a flat slice,
+// like NewDenseTensorOf.
+func NewDenseStringTensor(data []string, strides []int, signature string, dim []int) Tensor {
+	return newDense(box(data), strides, signature, dim, StringType)
 }
 
 func NewStringFunction(f func(s string) string) Function {
@@ -123,6 +356,6 @@ func NewStringFunction(f func(s string) string) Function {
 	}
 	return Function{
 		wrapper,
-		defaultString{},
+		StringType,
 	}
 }